@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"main/netbox-data-app/netbox"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// How long reference data (sites, racks, roles, types, tags, tenants...) is
+// trusted before it is fetched again. It rarely changes, so in practice it is
+// loaded once per session.
+const referenceDataTTL = 12 * time.Hour
+
+// Object kinds stored in the cache, named after their API endpoint
+const (
+	kindTenants       = "tenancy/tenants"
+	kindVLANs         = "ipam/vlans"
+	kindPrefixes      = "ipam/prefixes"
+	kindDevices       = "dcim/devices"
+	kindManufacturers = "dcim/manufacturers"
+	kindDeviceTypes   = "dcim/device-types"
+	kindDeviceRoles   = "dcim/device-roles"
+	kindSites         = "dcim/sites"
+	kindLocations     = "dcim/locations"
+	kindRacks         = "dcim/racks"
+	kindPlatforms     = "dcim/platforms"
+	kindTags          = "extras/tags"
+	kindCustomFields  = "extras/custom-fields"
+)
+
+// Reference data each screen loads, refetched on an explicit full refresh
+var screenReferenceKinds = map[screen][]string{
+	vlanScreen: {kindTenants},
+	deviceScreen: {kindManufacturers, kindDeviceTypes, kindDeviceRoles, kindSites,
+		kindLocations, kindRacks, kindPlatforms, kindTags, kindCustomFields},
+}
+
+// cacheEntry holds every cached object of one kind, keyed by ID. Objects are
+// kept as raw JSON so any type can be stored and the cache can be written to
+// disk as is.
+type cacheEntry struct {
+	Objects map[int]json.RawMessage `json:"objects"`
+	Fetched time.Time               `json:"fetched"`
+	// Stale is set when one of our own writes changed objects of this kind.
+	Stale bool `json:"-"`
+	// FromDisk is set for entries read from the on-disk cache. They only
+	// stand in while NetBox cannot be reached, so they are never fresh.
+	FromDisk bool `json:"-"`
+}
+
+// objectCache is an in-memory copy of the NetBox objects the app has
+// loaded. It is safe for concurrent use.
+type objectCache struct {
+	mu    sync.RWMutex
+	kinds map[string]*cacheEntry
+}
+
+func newObjectCache() *objectCache {
+	return &objectCache{kinds: make(map[string]*cacheEntry)}
+}
+
+// Fresh reports whether objects of kind were fetched this session within ttl
+// and have not been invalidated since.
+func (c *objectCache) Fresh(kind string, ttl time.Duration) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.kinds[kind]
+	return ok && !e.Stale && !e.FromDisk && time.Since(e.Fetched) < ttl
+}
+
+// Empty reports whether nothing is cached.
+func (c *objectCache) Empty() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.kinds) == 0
+}
+
+// Stale reports whether objects of kind were invalidated by one of our own
+// writes since they were cached.
+func (c *objectCache) Stale(kind string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.kinds[kind]
+	return ok && e.Stale
+}
+
+// Invalidate marks the given kinds stale so the next load refetches them.
+func (c *objectCache) Invalidate(kinds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, kind := range kinds {
+		if e, ok := c.kinds[kind]; ok {
+			e.Stale = true
+		}
+	}
+}
+
+// Clear drops every cached object.
+func (c *objectCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kinds = make(map[string]*cacheEntry)
+}
+
+// cacheStore replaces every cached object of kind with objects.
+func cacheStore[T any](c *objectCache, kind string, objects []T, id func(T) int) error {
+	e := &cacheEntry{
+		Objects: make(map[int]json.RawMessage, len(objects)),
+		Fetched: time.Now(),
+	}
+	for _, object := range objects {
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		e.Objects[id(object)] = raw
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kinds[kind] = e
+	return nil
+}
+
+// cacheList returns every cached object of kind ordered by ID.
+func cacheList[T any](c *objectCache, kind string) ([]T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.kinds[kind]
+	if !ok {
+		return nil, false
+	}
+
+	ids := make([]int, 0, len(e.Objects))
+	for id := range e.Objects {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	objects := make([]T, 0, len(ids))
+	for _, id := range ids {
+		var object T
+		if err := json.Unmarshal(e.Objects[id], &object); err != nil {
+			return nil, false
+		}
+		objects = append(objects, object)
+	}
+	return objects, true
+}
+
+// Save writes the cache to path.
+func (c *objectCache) Save(path string) error {
+	c.mu.RLock()
+	data, err := json.Marshal(c.kinds)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves half a cache
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load replaces the cache with the contents of path.
+func (c *objectCache) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	kinds := make(map[string]*cacheEntry)
+	if err := json.Unmarshal(data, &kinds); err != nil {
+		return fmt.Errorf("reading cache %s: %w", path, err)
+	}
+	for _, e := range kinds {
+		e.FromDisk = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kinds = kinds
+	return nil
+}
+
+// cacheFile returns where the on-disk cache of the NetBox instance at domain
+// is kept.
+func cacheFile(domain string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(domain, "https://"), "http://")
+	name = strings.NewReplacer("/", "_", ":", "_").Replace(strings.TrimRight(name, "/"))
+	return filepath.Join(dir, "netbox-data-app", name+".json"), nil
+}
+
+// saveCache writes the object cache to disk when that is enabled.
+func saveCache() {
+	if !diskCacheEnabled {
+		return
+	}
+
+	path, err := cacheFile(cacheDomain)
+	if err != nil {
+		logError("locating cache", err)
+		return
+	}
+	if err := objects.Save(path); err != nil {
+		logError("saving cache", err)
+	}
+}
+
+// loadDiskCache replaces the object cache with the on-disk copy for the
+// current NetBox instance, if there is one.
+func loadDiskCache() {
+	objects.Clear()
+	if !diskCacheEnabled {
+		return
+	}
+
+	path, err := cacheFile(cacheDomain)
+	if err != nil {
+		logError("locating cache", err)
+		return
+	}
+	if err := objects.Load(path); err != nil && !os.IsNotExist(err) {
+		logError("loading cache", err)
+	}
+}
+
+// offlineBanner tells the user the app is showing cached data.
+func offlineBanner() imgui.Widget {
+	return imgui.Condition(offline.Load(),
+		imgui.Row(
+			imgui.Label("NetBox is unreachable: showing cached data, changes are disabled"),
+			imgui.Button("Reconnect").OnClick(logIn),
+		),
+		imgui.Dummy(0, 0),
+	)
+}
+
+// listCached returns the objects at path from the cache while they are
+// younger than ttl and fetches and caches them otherwise. When NetBox cannot
+// be reached, or the app runs offline, the cached copy is used even if it is
+// stale.
+func listCached[T any](kind, path string, ttl time.Duration, id func(T) int) ([]T, error) {
+	if offline.Load() || objects.Fresh(kind, ttl) {
+		if cached, ok := cacheList[T](objects, kind); ok {
+			return cached, nil
+		}
+		if offline.Load() {
+			return nil, fmt.Errorf("%s is not in the offline cache", kind)
+		}
+	}
+
+	all, err := netbox.ListAll[T](ctx, nbClient, path, nil, netbox.ListOptions{})
+	if err != nil {
+		if cached, ok := cacheList[T](objects, kind); ok {
+			logInfo("Using cached %s: %v", kind, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if err := cacheStore(objects, kind, all, id); err != nil {
+		logError("caching "+kind, err)
+	}
+	return all, nil
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// command is one subcommand of the command-line interface. args are the
+// arguments after the command name.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":   {"serve [-addr 127.0.0.1:8080] [-api-token-file FILE]", runServer},
+	"export":  {"export prefixes|devices [-o FILE]", runExport},
+	"import":  {"import devices [-sheet NAME] [-template NAME] [-map field=Header,...] [-save-template] [-mode create|update|upsert] [-match name|serial] [-batch N] [-report FILE] FILE | history | rollback ID|-yes", runImport},
+	"predict": {"predict [-csv FILE]", runPredict},
+	"vlan":    {"vlan create -name NAME -vid VID [-desc TEXT] [-tenant NAME]", runVLAN},
+	"device":  {"device create -name NAME -type TYPE -role ROLE -site SITE -manufacturer NAME [-tenant NAME] [-serial SERIAL] [-status STATUS] [-location NAME] [-rack NAME -position U -face front|rear] [-platform NAME] [-asset-tag TAG] [-airflow DIRECTION] [-tags A,B] [-cf name=value]...", runDevice},
+}
+
+// errUsage makes runCLI print the usage of the command that returned it.
+var errUsage = errors.New("invalid usage")
+
+// runCLI runs the subcommand named by args[0] and returns the process exit
+// code.
+func runCLI(args []string) int {
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	logOutput = os.Stderr
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	err := cmd.run(args[1:])
+	if err == nil && dryRun.Load() {
+		err = printPlan(os.Stdout)
+	}
+	if err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "usage: netbox-data-app %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printPlan writes the changes a dry run collected to w as JSON. It fails
+// when any of them did not validate, so scripts can stop before the real
+// run.
+func printPlan(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan.Writes()); err != nil {
+		return err
+	}
+	if n := plan.Problems(); n > 0 {
+		return fmt.Errorf("dry run: %d planned changes have problems", n)
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: netbox-data-app [command]")
+	fmt.Fprintln(w, "Without a command the GUI is started. Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+}
+
+// connectionFlags adds the profile, NetBox address and token flags to fs.
+// The returned function connects once the flags have been parsed. Flags take
+// precedence over the environment, which takes precedence over the config
+// file.
+func connectionFlags(fs *flag.FlagSet) func() error {
+	configPath := fs.String("config", "", "config file (NETBOX_DATA_APP_CONFIG)")
+	profileName := fs.String("profile", "", "config profile to use (NETBOX_PROFILE)")
+	domain := fs.String("domain", "", "NetBox address (NETBOX_URL)")
+	token := fs.String("token", "", "NetBox API token (NETBOX_TOKEN); prefer -token-file, which stays out of shell history")
+	tokenFile := fs.String("token-file", "", "file holding the NetBox API token (NETBOX_TOKEN_FILE)")
+	dry := fs.Bool("dry-run", false, "print the changes as a plan instead of sending them to NetBox")
+
+	return func() error {
+		dryRun.Store(*dry)
+
+		path := *configPath
+		if path == "" {
+			var err error
+			if path, err = configFile(); err != nil {
+				return err
+			}
+		}
+		cfg, err := loadConfig(path)
+		if err != nil {
+			return err
+		}
+		profile, err := cfg.Profile(*profileName)
+		if err != nil {
+			return err
+		}
+
+		if *domain != "" {
+			profile.URL = *domain
+		}
+		if *tokenFile != "" {
+			profile.Token, profile.TokenEnv, profile.TokenFile = "", "", *tokenFile
+		}
+		if *token == "" {
+			if *token, err = profile.ResolveToken(); err != nil {
+				return err
+			}
+		}
+		// Fall back to the token saved from the login window
+		if *token == "" && os.Getenv(envPassphrase) != "" {
+			if *token, err = savedToken(os.Getenv(envPassphrase), profile.URL); err != nil {
+				return err
+			}
+		}
+		if profile.URL == "" || *token == "" {
+			return errors.New("a NetBox address and token are required (-profile, -domain/-token-file, NETBOX_URL/NETBOX_TOKEN or a saved token with NETBOX_PASSPHRASE)")
+		}
+
+		if err := connect(profile, *token); err != nil {
+			return err
+		}
+		if _, err := detectVersion(ctx); err != nil {
+			logError("detecting NetBox version", err)
+		}
+		cacheDomain = profile.URL
+		loadDiskCache()
+		return nil
+	}
+}
+
+// loadRegistry fetches the reference data the importer and the device form
+// resolve names with. It returns the device snapshot it loaded on the way.
+func loadRegistry() (*deviceSnapshot, error) {
+	if err := loadTenants(); err != nil {
+		return nil, err
+	}
+	devices, err := loadDeviceTable(nil)
+	if err != nil {
+		return nil, err
+	}
+	publishDeviceReferences(devices)
+	saveCache()
+	return devices, nil
+}
+
+// loadTenants fetches the tenants into the registry, without the VLANs and
+// prefixes the VLAN screen loads with them.
+func loadTenants() error {
+	tenants, err := listCached(kindTenants, "/api/tenancy/tenants/", referenceDataTTL, func(t Tenant) int { return t.ID })
+	if err != nil {
+		return fmt.Errorf("fetching tenants: %w", err)
+	}
+	updateRegistry(func(r *registry) {
+		r.Tenants = newRefList(tenantItems(tenants))
+	})
+	return nil
+}
+
+func runExport(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	what := args[0]
+
+	fs := flag.NewFlagSet("export "+what, flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	output := fs.String("o", "", "file to write")
+	filter := fs.String("filter", "", "only export devices whose name contains this")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch what {
+	case "prefixes":
+		if err := connectNetBox(); err != nil {
+			return err
+		}
+		if *output == "" {
+			*output = exportPath("prefixes.xlsx")
+		}
+		prefixes, err := fetchPrefixes()
+		if err != nil {
+			return fmt.Errorf("fetching prefixes: %w", err)
+		}
+		if err := exportPrefixes(prefixes, *output); err != nil {
+			return fmt.Errorf("saving %s: %w", *output, err)
+		}
+	case "devices":
+		if err := connectNetBox(); err != nil {
+			return err
+		}
+		if *output == "" {
+			*output = exportPath("devices_data.xlsx")
+		}
+		snap, err := loadRegistry()
+		if err != nil {
+			return err
+		}
+		if err := exportDevices(snap.Devices, *filter, *output); err != nil {
+			return fmt.Errorf("saving %s: %w", *output, err)
+		}
+	default:
+		return errUsage
+	}
+
+	fmt.Printf("Excel file created successfully: %s\n", *output)
+	return nil
+}
+
+func runImport(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "devices":
+		return runImportDevices(args[1:])
+	case "history":
+		return runImportHistory(args[1:])
+	case "rollback":
+		return runImportRollback(args[1:])
+	}
+	return errUsage
+}
+
+func runImportDevices(args []string) error {
+	fs := flag.NewFlagSet("import devices", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	report := fs.String("report", "", "write the outcome of every row to this spreadsheet")
+	sheet := fs.String("sheet", "", "sheet of the workbook to import, the active one by default")
+	template := fs.String("template", "", "saved column mapping to use, named after the file by default")
+	mapping := fs.String("map", "", "map fields to column headers, e.g. name=Hostname,site=Location")
+	saveTemplate := fs.Bool("save-template", false, "save the column mapping under the template name")
+	batchSize := fs.Int("batch", 0, "devices created per request (default from the profile, else 100)")
+	mode := fs.String("mode", string(importCreateOnly), "create, update or upsert existing devices")
+	match := fs.String("match", "name", "match existing devices by name (and site) or serial")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	opts := importOptions{MatchSerial: *match == "serial"}
+	var err error
+	if opts.Mode, err = parseImportMode(*mode); err != nil {
+		return err
+	}
+	if *match != "name" && *match != "serial" {
+		return fmt.Errorf("-match: want name or serial, not %q", *match)
+	}
+
+	path := fs.Arg(0)
+	if *template == "" {
+		*template = templateName(path)
+	}
+	table, m, err := openImport(path, *sheet, *template)
+	if err != nil {
+		return err
+	}
+	if *mapping != "" {
+		if table.Header == nil {
+			return fmt.Errorf("%s has no header row to map", path)
+		}
+		for _, pair := range strings.Split(*mapping, ",") {
+			key, name, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("-map: %q is not field=Header", pair)
+			}
+			if err := m.Set(table.Header, strings.TrimSpace(key), strings.TrimSpace(name)); err != nil {
+				return fmt.Errorf("-map: %w", err)
+			}
+		}
+	}
+	if *saveTemplate {
+		if table.Header == nil {
+			return fmt.Errorf("%s has no header row to save a template for", path)
+		}
+		if err := saveImportTemplate(*template, newImportTemplate(m, table.Header, table.Sheet)); err != nil {
+			return fmt.Errorf("saving template %s: %w", *template, err)
+		}
+	}
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+	snap, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	if *batchSize < 1 {
+		*batchSize = activeProfile.importBatchSize()
+	}
+	result := importDevices(resolveImportRows(table, m, currentRegistry(), snap.Devices, opts), *batchSize, filepath.Base(path))
+	done := result.Rows
+	failed := 0
+	for _, row := range done {
+		if row.Outcome == outcomeFailed {
+			failed++
+		}
+		switch {
+		case row.Reason != "":
+			logInfo("Row %d %q %s: %s", row.Line, row.Request.Name, row.Outcome, row.Reason)
+		case len(row.Changes) > 0:
+			logInfo("Row %d %q %s: %s", row.Line, row.Request.Name, row.Outcome, describeChanges(row.Changes, currentRegistry()))
+		}
+	}
+	logWrite("Import finished: %s", result.Summary())
+	if result.Journal != nil && len(result.Journal.Entries) > 0 {
+		logInfo("Undo with: netbox-data-app import rollback %s", result.Journal.ID)
+	}
+
+	if *report != "" {
+		if err := exportImportReport(done, *report); err != nil {
+			return fmt.Errorf("saving %s: %w", *report, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d devices could not be created", failed)
+	}
+	return nil
+}
+
+// runImportHistory lists the imports into the NetBox in use, newest first.
+func runImportHistory(args []string) error {
+	fs := flag.NewFlagSet("import history", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+
+	journals, err := listJournals(nbClient.BaseURL())
+	if err != nil {
+		return err
+	}
+	for _, j := range journals {
+		fmt.Printf("%s  %s\n", j.ID, j.Summary())
+	}
+	return nil
+}
+
+// runImportRollback rolls back the import with the given journal ID, the
+// latest one by default.
+func runImportRollback(args []string) error {
+	fs := flag.NewFlagSet("import rollback", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	yes := fs.Bool("yes", false, "roll back the latest import when no ID is given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+
+	var j *importJournal
+	if fs.NArg() == 1 {
+		var err error
+		if j, err = loadJournal(fs.Arg(0)); err != nil {
+			return err
+		}
+	} else {
+		journals, err := listJournals(nbClient.BaseURL())
+		if err != nil {
+			return err
+		}
+		if len(journals) == 0 {
+			return errors.New("no imports to roll back")
+		}
+		j = journals[0]
+		if !*yes {
+			fmt.Printf("Latest import: %s  %s\n", j.ID, j.Summary())
+			return errors.New("rolling back deletes devices: pass the import ID, or -yes for the latest import")
+		}
+	}
+	if j.RolledBack != nil {
+		return fmt.Errorf("import %s was already rolled back", j.ID)
+	}
+
+	logInfo("Rolling back import %s of %s:", j.ID, j.Source)
+	for _, e := range j.Entries {
+		switch {
+		case e.RolledBack:
+		case e.Action == journalCreated:
+			logInfo("  delete device %q (%d) created from row %d", e.Name, e.ID, e.Line)
+		default:
+			logInfo("  restore %s of device %q (%d) updated from row %d", strings.Join(slices.Sorted(maps.Keys(e.Restore)), ", "), e.Name, e.ID, e.Line)
+		}
+	}
+
+	if err := rollbackImport(j); err != nil {
+		return err
+	}
+	logWrite("Import of %s rolled back", j.Source)
+	return nil
+}
+
+func runPredict(args []string) error {
+	fs := flag.NewFlagSet("predict", flag.ContinueOnError)
+	csvPath := fs.String("csv", "devices_data.csv", "device list to train on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	summary, err := predictDeviceLocation(*csvPath)
+	if err != nil {
+		return err
+	}
+	fmt.Println(summary)
+	return nil
+}
+
+func runVLAN(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("vlan create", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	name := fs.String("name", "", "VLAN name")
+	vid := fs.Int("vid", 0, "numeric VLAN ID (1-4094)")
+	desc := fs.String("desc", "", "description")
+	tenant := fs.String("tenant", "", "tenant name")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *name == "" || *vid == 0 {
+		return errUsage
+	}
+
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+
+	vlanData := VLANRequest{Vid: *vid, Name: *name, Description: *desc}
+	if *tenant != "" {
+		if err := loadTenants(); err != nil {
+			return err
+		}
+		id, err := lookupRef(currentRegistry().Tenants, "tenant", *tenant)
+		if err != nil {
+			return err
+		}
+		vlanData.Tenant = id
+	}
+
+	if err := createVLAN(vlanData); err != nil {
+		return fmt.Errorf("creating VLAN: %w", err)
+	}
+	logWrite("VLAN successfully created")
+	return nil
+}
+
+func runDevice(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("device create", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	name := fs.String("name", "", "device name")
+	serial := fs.String("serial", "", "serial number")
+	deviceType := fs.String("type", "", "device type")
+	role := fs.String("role", "", "device role")
+	site := fs.String("site", "", "site")
+	manufacturer := fs.String("manufacturer", "", "manufacturer")
+	tenant := fs.String("tenant", "", "tenant")
+	status := fs.String("status", "active", "status, e.g. active or planned")
+	location := fs.String("location", "", "location within the site")
+	rack := fs.String("rack", "", "rack at the site")
+	position := fs.Float64("position", 0, "lowest rack unit the device occupies")
+	face := fs.String("face", "", "rack face, front or rear")
+	platform := fs.String("platform", "", "platform")
+	assetTag := fs.String("asset-tag", "", "asset tag")
+	airflow := fs.String("airflow", "", "airflow, e.g. front-to-rear")
+	tags := fs.String("tags", "", "comma-separated tags")
+	customFields := map[string]string{}
+	fs.Func("cf", "custom field as name=value, repeatable", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("%q is not name=value", s)
+		}
+		customFields[strings.TrimSpace(name)] = value
+		return nil
+	})
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *name == "" || *deviceType == "" || *role == "" || *site == "" || *manufacturer == "" {
+		return errUsage
+	}
+
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+	if _, err := loadRegistry(); err != nil {
+		return err
+	}
+	reg := currentRegistry()
+
+	deviceData := DeviceRequest{
+		Name:     *name,
+		Serial:   *serial,
+		Status:   *status,
+		Position: *position,
+		Face:     *face,
+		AssetTag: *assetTag,
+		Airflow:  *airflow,
+	}
+	var err error
+	if deviceData.DeviceType, err = lookupRef(reg.DeviceTypes, "device type", *deviceType); err != nil {
+		return err
+	}
+	if deviceData.DeviceRole, err = lookupRef(reg.DeviceRoles, "device role", *role); err != nil {
+		return err
+	}
+	if deviceData.Site, err = lookupRef(reg.Sites, "site", *site); err != nil {
+		return err
+	}
+	if deviceData.Manufacturer, err = lookupRef(reg.Manufacturers, "manufacturer", *manufacturer); err != nil {
+		return err
+	}
+	if *tenant != "" {
+		if deviceData.Tenant, err = lookupRef(reg.Tenants, "tenant", *tenant); err != nil {
+			return err
+		}
+	}
+	if *location != "" {
+		if deviceData.Location, err = lookupRef(reg.Locations.Scope(deviceData.Site), "location", *location); err != nil {
+			return err
+		}
+	}
+	if *rack != "" {
+		if deviceData.Rack, err = lookupRef(reg.Racks.Scope(deviceData.Site), "rack", *rack); err != nil {
+			return err
+		}
+	}
+	if *platform != "" {
+		if deviceData.Platform, err = lookupRef(reg.Platforms, "platform", *platform); err != nil {
+			return err
+		}
+	}
+	for _, tag := range splitList(*tags) {
+		id, err := lookupRef(reg.Tags, "tag", tag)
+		if err != nil {
+			return err
+		}
+		deviceData.Tags = append(deviceData.Tags, id)
+	}
+	for name, value := range customFields {
+		field, ok := findCustomField(reg.CustomFields, name)
+		if !ok {
+			return fmt.Errorf("no custom field %q for devices", name)
+		}
+		if deviceData.CustomFields == nil {
+			deviceData.CustomFields = map[string]interface{}{}
+		}
+		if deviceData.CustomFields[field.Name], err = field.Parse(value); err != nil {
+			return err
+		}
+	}
+
+	if err := createDevice(deviceData); err != nil {
+		return fmt.Errorf("creating device: %w", err)
+	}
+	logWrite("Device created successfully!")
+	return nil
+}
+
+// lookupRef returns the ID of the object in l named or slugged name.
+func lookupRef(l *refList, kind, name string) (int, error) {
+	m := l.Match(name)
+	if !m.Matched() {
+		return 0, errors.New(m.Problem(kind, name))
+	}
+	return m.Item.ID, nil
+}
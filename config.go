@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables that override the config file, mostly for headless
+// use
+const (
+	envConfig    = "NETBOX_DATA_APP_CONFIG"
+	envProfile   = "NETBOX_PROFILE"
+	envURL       = "NETBOX_URL"
+	envToken     = "NETBOX_TOKEN"
+	envTokenFile = "NETBOX_TOKEN_FILE"
+	envPageSize  = "NETBOX_PAGE_SIZE"
+	envExportDir = "NETBOX_EXPORT_DIR"
+	envCAFile    = "NETBOX_CA_FILE"
+	envAPIToken  = "NETBOX_DATA_APP_API_TOKEN"
+)
+
+// Profile describes how to reach one NetBox instance.
+type Profile struct {
+	Name string `yaml:"-"`
+	URL  string `yaml:"url"`
+	// The token is read from the first of Token, TokenEnv and TokenFile that
+	// is set. Prefer TokenEnv or TokenFile so the config can be shared.
+	Token     string     `yaml:"token,omitempty"`
+	TokenEnv  string     `yaml:"token_env,omitempty"`
+	TokenFile string     `yaml:"token_file,omitempty"`
+	TLS       TLSOptions `yaml:"tls,omitempty"`
+	// Proxy is the URL of the proxy NetBox is reached through. When empty
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honoured.
+	Proxy    string   `yaml:"proxy,omitempty"`
+	Timeouts Timeouts `yaml:"timeouts,omitempty"`
+	PageSize int      `yaml:"page_size,omitempty"`
+	Retry    Retry    `yaml:"retry,omitempty"`
+	// MaxConcurrentRequests caps the requests in flight to NetBox; 0 uses
+	// the default and a negative value means no limit.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests,omitempty"`
+	// ExportDir is where spreadsheets are written; the working directory
+	// when empty.
+	ExportDir string `yaml:"export_dir,omitempty"`
+	// ImportBatchSize is how many devices the importer creates per request.
+	ImportBatchSize int `yaml:"import_batch_size,omitempty"`
+}
+
+// Config is the contents of the config file.
+type Config struct {
+	// Default names the profile used when none is picked.
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// The profile offered when there is no config file
+var demoProfile = Profile{Name: "demo", URL: "https://demo.netbox.dev"}
+
+// configFile returns where the config file is read from.
+func configFile() (string, error) {
+	if path := os.Getenv(envConfig); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "netbox-data-app", "config.yaml"), nil
+}
+
+// defaultConfig is used when there is no config file.
+func defaultConfig() *Config {
+	demo := demoProfile
+	return &Config{
+		Default:  demo.Name,
+		Profiles: map[string]*Profile{demo.Name: &demo},
+	}
+}
+
+// loadConfig reads the config file at path. A missing file yields the
+// default config.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return defaultConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if len(cfg.Profiles) == 0 {
+		return nil, fmt.Errorf("reading config %s: no profiles defined", path)
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil {
+			return nil, fmt.Errorf("reading config %s: profile %q is empty", path, name)
+		}
+		p.Name = name
+	}
+	if cfg.Default == "" {
+		cfg.Default = cfg.Names()[0]
+	}
+	return cfg, nil
+}
+
+// Names returns the profile names in alphabetical order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns a copy of the named profile with the environment overrides
+// applied. An empty name picks NETBOX_PROFILE or the default profile.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = os.Getenv(envProfile)
+	}
+	if name == "" {
+		name = c.Default
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile named %q", name)
+	}
+
+	profile := *p
+	if v := os.Getenv(envURL); v != "" {
+		profile.URL = v
+	}
+	if v := os.Getenv(envTokenFile); v != "" {
+		profile.Token, profile.TokenEnv, profile.TokenFile = "", "", v
+	}
+	if v := os.Getenv(envToken); v != "" {
+		profile.Token = v
+	}
+	if v := os.Getenv(envPageSize); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return Profile{}, fmt.Errorf("%s: %w", envPageSize, err)
+		}
+		profile.PageSize = size
+	}
+	if v := os.Getenv(envExportDir); v != "" {
+		profile.ExportDir = v
+	}
+	if v := os.Getenv(envCAFile); v != "" {
+		profile.TLS.CAFile = v
+	}
+	return profile, nil
+}
+
+// ResolveToken returns the API token of the profile.
+func (p Profile) ResolveToken() (string, error) {
+	switch {
+	case p.Token != "":
+		return p.Token, nil
+	case p.TokenEnv != "":
+		token := os.Getenv(p.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("profile %s: %s is not set", p.Name, p.TokenEnv)
+		}
+		return token, nil
+	case p.TokenFile != "":
+		data, err := os.ReadFile(p.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
+
+// exportPath returns where the spreadsheet called name is written for the
+// active profile.
+func exportPath(name string) string {
+	if activeProfile.ExportDir == "" {
+		return name
+	}
+	return filepath.Join(activeProfile.ExportDir, name)
+}
+
+// Requests in flight to NetBox when a profile does not say otherwise, as
+// many as the device detail workers make
+const defaultMaxConcurrentRequests = deviceDetailWorkers
+
+// maxConcurrentRequests returns how many requests may be in flight to
+// NetBox at once, 0 for no limit.
+func (p Profile) maxConcurrentRequests() int {
+	switch {
+	case p.MaxConcurrentRequests < 0:
+		return 0
+	case p.MaxConcurrentRequests == 0:
+		return defaultMaxConcurrentRequests
+	}
+	return p.MaxConcurrentRequests
+}
+
+// importBatchSize returns how many devices the importer creates per request.
+func (p Profile) importBatchSize() int {
+	if p.ImportBatchSize > 0 {
+		return p.ImportBatchSize
+	}
+	return defaultImportBatchSize
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Environment variable holding the passphrase of the credential file for
+// headless use
+const envPassphrase = "NETBOX_PASSPHRASE"
+
+var errWrongPassphrase = errors.New("wrong passphrase or damaged credential file")
+
+// credentialFile is the on-disk form of the saved tokens. The tokens are kept
+// as a JSON map of NetBox address to token, sealed with AES-256-GCM under a
+// key derived from the passphrase with scrypt.
+type credentialFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// scrypt parameters recommended for interactive logins
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// credentialPath returns where saved tokens are kept.
+func credentialPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "netbox-data-app", "credentials.json"), nil
+}
+
+func credentialCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadCredentials decrypts the tokens saved at path. A missing file yields no
+// tokens.
+func loadCredentials(path, passphrase string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("reading %s: unsupported version %d", path, file.Version)
+	}
+
+	aead, err := credentialCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+
+	tokens := map[string]string{}
+	if err := json.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+// saveCredential stores token for the NetBox at domain in the credential
+// file at path, keeping the tokens already saved there. The file must have
+// been saved with the same passphrase.
+func saveCredential(path, passphrase, domain, token string) error {
+	if passphrase == "" {
+		return errors.New("a passphrase is required to save the token")
+	}
+
+	tokens, err := loadCredentials(path, passphrase)
+	if err != nil {
+		return err
+	}
+	tokens[domain] = token
+
+	plain, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	// A fresh salt and nonce on every save
+	file := credentialFile{
+		Version: 1,
+		Salt:    make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := credentialCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never loses saved tokens
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// savedToken returns the token saved for the NetBox at domain, or "" when
+// none was saved.
+func savedToken(passphrase, domain string) (string, error) {
+	path, err := credentialPath()
+	if err != nil {
+		return "", err
+	}
+	tokens, err := loadCredentials(path, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return tokens[domain], nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// customField is the definition of a NetBox custom field.
+type customField struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  struct {
+		Value string `json:"value"`
+	} `json:"type"`
+	Required bool `json:"required"`
+	// ObjectTypes are the models the field applies to.
+	ObjectTypes []string `json:"object_types"`
+}
+
+func customFieldID(f customField) int { return f.ID }
+
+// appliesTo reports whether the field is defined for objects of model, e.g.
+// "dcim.device".
+func (f customField) appliesTo(model string) bool {
+	for _, t := range f.ObjectTypes {
+		if t == model {
+			return true
+		}
+	}
+	return false
+}
+
+// Title is the label the field is shown with.
+func (f customField) Title() string {
+	title := f.Label
+	if title == "" {
+		title = f.Name
+	}
+	if f.Required {
+		title += " *"
+	}
+	return title
+}
+
+// getDeviceCustomFields returns the custom fields defined for devices,
+// sorted by name.
+func getDeviceCustomFields() []customField {
+	all, err := listCached(kindCustomFields, "/api/extras/custom-fields/", referenceDataTTL, customFieldID)
+	if err != nil {
+		logError("fetching custom fields", err)
+	}
+
+	var fields []customField
+	for _, f := range all {
+		if f.appliesTo("dcim.device") {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// findCustomField returns the field called name, ignoring case.
+func findCustomField(fields []customField, name string) (customField, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return customField{}, false
+}
+
+// Parse converts s to the value NetBox expects for the field. Lists are
+// separated by commas.
+func (f customField) Parse(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	var v interface{}
+	var err error
+	switch f.Type.Value {
+	case "integer":
+		v, err = strconv.Atoi(s)
+	case "decimal":
+		v, err = strconv.ParseFloat(s, 64)
+	case "boolean":
+		switch strings.ToLower(s) {
+		case "yes", "y":
+			v = true
+		case "no", "n":
+			v = false
+		default:
+			v, err = strconv.ParseBool(s)
+		}
+	case "object":
+		v, err = strconv.Atoi(s)
+	case "multiselect":
+		v = splitList(s)
+	case "multiobject":
+		ids := []int{}
+		for _, item := range splitList(s) {
+			id, ierr := strconv.Atoi(item)
+			if ierr != nil {
+				err = ierr
+				break
+			}
+			ids = append(ids, id)
+		}
+		v = ids
+	case "json":
+		err = json.Unmarshal([]byte(s), &v)
+	default:
+		v = s
+	}
+	if err != nil {
+		return nil, fmt.Errorf("custom field %s: %q is not a valid %s", f.Name, s, f.Type.Value)
+	}
+	return v, nil
+}
+
+// splitList splits a comma or semicolon separated cell, dropping empty
+// items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,1033 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/netbox-data-app/netbox"
+
+	imgui "github.com/AllenDang/giu"
+	excel "github.com/xuri/excelize/v2"
+)
+
+// What happened to a row of an import
+const (
+	outcomeCreated   = "created"
+	outcomeUpdated   = "updated"
+	outcomeUnchanged = "unchanged"
+	outcomePlanned   = "planned"
+	outcomeSkipped   = "skipped"
+	outcomeFailed    = "failed"
+)
+
+// importRow is one spreadsheet row of a device import together with what it
+// resolved to and, once imported, what happened to it.
+type importRow struct {
+	// Line is the row number in the spreadsheet, starting at 1.
+	Line  int      `json:"line"`
+	Cells []string `json:"cells"`
+	// Request is the device the row resolved to; IDs are 0 where a name
+	// could not be resolved.
+	Request DeviceRequest `json:"request"`
+	// Choices are the reference cells that need the user to pick an object.
+	Choices []importChoice `json:"choices,omitempty"`
+	// Invalid lists the cells whose value could not be read.
+	Invalid  []string `json:"invalid,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+	// Action is what the import does with the row. Rows that update a
+	// device carry its ID in Existing and the fields they change.
+	Action   string        `json:"action,omitempty"`
+	Existing int           `json:"existing,omitempty"`
+	Changes  []fieldChange `json:"changes,omitempty"`
+	// Before is the device to update as fetched right before writing.
+	Before   *DeviceDetails `json:"-"`
+	Selected bool           `json:"selected"`
+	Outcome  string         `json:"outcome,omitempty"`
+	// ID is the ID NetBox gave the created device.
+	ID     int    `json:"id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Cell returns column col of the row, or "" when the row is shorter or col
+// is -1.
+func (r importRow) Cell(col int) string {
+	if col >= 0 && col < len(r.Cells) {
+		return strings.TrimSpace(r.Cells[col])
+	}
+	return ""
+}
+
+// Valid reports whether the row can be imported.
+func (r importRow) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// importRef is an import field that names a reference object.
+type importRef struct {
+	Field    int // index into importFields
+	Kind     string
+	Required bool
+	List     func(*registry) *refList
+	// ID is where the resolved ID goes.
+	ID func(*DeviceRequest) *int
+	// IDs is set instead of ID for fields that name several objects,
+	// separated by commas.
+	IDs func(*DeviceRequest) *[]int
+	// BySite matches names among the objects of the row's site.
+	BySite bool
+}
+
+var importRefs = []importRef{
+	{Field: fieldTenant, Kind: "tenant", List: func(r *registry) *refList { return r.Tenants }, ID: func(d *DeviceRequest) *int { return &d.Tenant }},
+	{Field: fieldManufacturer, Kind: "manufacturer", Required: true, List: func(r *registry) *refList { return r.Manufacturers }, ID: func(d *DeviceRequest) *int { return &d.Manufacturer }},
+	{Field: fieldRole, Kind: "device role", Required: true, List: func(r *registry) *refList { return r.DeviceRoles }, ID: func(d *DeviceRequest) *int { return &d.DeviceRole }},
+	{Field: fieldSite, Kind: "site", Required: true, List: func(r *registry) *refList { return r.Sites }, ID: func(d *DeviceRequest) *int { return &d.Site }},
+	{Field: fieldType, Kind: "device type", Required: true, List: func(r *registry) *refList { return r.DeviceTypes }, ID: func(d *DeviceRequest) *int { return &d.DeviceType }},
+	{Field: fieldLocation, Kind: "location", BySite: true, List: func(r *registry) *refList { return r.Locations }, ID: func(d *DeviceRequest) *int { return &d.Location }},
+	{Field: fieldRack, Kind: "rack", BySite: true, List: func(r *registry) *refList { return r.Racks }, ID: func(d *DeviceRequest) *int { return &d.Rack }},
+	{Field: fieldPlatform, Kind: "platform", List: func(r *registry) *refList { return r.Platforms }, ID: func(d *DeviceRequest) *int { return &d.Platform }},
+	{Field: fieldTags, Kind: "tag", List: func(r *registry) *refList { return r.Tags }, IDs: func(d *DeviceRequest) *[]int { return &d.Tags }},
+}
+
+// importChoice is a reference cell that did not resolve to exactly one
+// object, with the objects the user may pick instead.
+type importChoice struct {
+	Ref int `json:"-"` // index into importRefs
+	// Slot is the index into the IDs of a field that names several
+	// objects.
+	Slot       int       `json:"-"`
+	Value      string    `json:"value"`
+	Candidates []refItem `json:"candidates,omitempty"`
+	Ambiguous  bool      `json:"ambiguous,omitempty"`
+	Problem    string    `json:"problem"`
+	// Pick is the chosen candidate plus one, 0 while undecided.
+	Pick int32 `json:"-"`
+}
+
+// target returns where the ID picked for the choice goes in d.
+func (c importChoice) target(d *DeviceRequest) *int {
+	r := importRefs[c.Ref]
+	if r.IDs != nil {
+		return &(*r.IDs(d))[c.Slot]
+	}
+	return r.ID(d)
+}
+
+// resolveImportRows turns the rows of table into device requests, reading
+// the fields from the columns m maps them to and resolving names with reg.
+// Each row is matched against existing, the devices already in NetBox, as
+// opts say. Every row is returned; rows with problems are not selected.
+func resolveImportRows(table *importTable, m columnMapping, reg *registry, existing []DeviceDetails, opts importOptions) []importRow {
+	cfColumns := customFieldColumns(table.Header)
+	var resolved []importRow
+	for i, cells := range table.Rows {
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+		row := importRow{Line: table.FirstLine + i, Cells: cells}
+		row.Request = DeviceRequest{
+			Name:     row.Cell(m[fieldName]),
+			Serial:   row.Cell(m[fieldSerial]),
+			Status:   strings.ToLower(row.Cell(m[fieldStatus])),
+			AssetTag: row.Cell(m[fieldAssetTag]),
+			Face:     strings.ToLower(row.Cell(m[fieldFace])),
+			Airflow:  strings.ToLower(row.Cell(m[fieldAirflow])),
+		}
+		readImportCells(&row, m, reg, cfColumns)
+
+		// importRefs has the site before the objects matched within it
+		for ref, r := range importRefs {
+			names := []string{row.Cell(m[r.Field])}
+			if r.IDs != nil {
+				names = splitList(names[0])
+				*r.IDs(&row.Request) = make([]int, len(names))
+			}
+			list := r.List(reg)
+			if r.BySite {
+				list = list.Scope(row.Request.Site)
+			}
+
+			for slot, name := range names {
+				if name == "" {
+					continue
+				}
+				choice := importChoice{Ref: ref, Slot: slot, Value: name}
+				m := list.Match(name)
+				if m.Matched() {
+					*choice.target(&row.Request) = m.Item.ID
+					continue
+				}
+				choice.Candidates, choice.Ambiguous = m.Candidates, m.Ambiguous
+				choice.Problem = m.Problem(r.Kind, name)
+				row.Choices = append(row.Choices, choice)
+			}
+		}
+		resolved = append(resolved, row)
+	}
+
+	checkImportRows(resolved, existing, opts)
+	for i := range resolved {
+		resolved[i].Selected = resolved[i].Valid()
+	}
+	return resolved
+}
+
+// readImportCells reads the cells of row that are not names: the rack
+// position, the values NetBox picks from a fixed set, and the custom fields
+// of the cf_ columns in cfColumns. Cells that do not read are recorded in
+// row.Invalid.
+func readImportCells(row *importRow, m columnMapping, reg *registry, cfColumns map[int]string) {
+	if cell := row.Cell(m[fieldPosition]); cell != "" {
+		position, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToUpper(cell), "U"), 64)
+		if err != nil || position <= 0 {
+			row.Invalid = append(row.Invalid, fmt.Sprintf("rack position %q is not a U number", cell))
+		}
+		row.Request.Position = position
+	}
+	if row.Request.Status != "" && !oneOf(row.Request.Status, deviceStatuses) {
+		row.Invalid = append(row.Invalid, fmt.Sprintf("unknown status %q", row.Request.Status))
+	}
+	if !oneOf(row.Request.Face, deviceFaces) {
+		row.Invalid = append(row.Invalid, fmt.Sprintf("unknown rack face %q, want front or rear", row.Request.Face))
+	}
+	if !oneOf(row.Request.Airflow, deviceAirflows) {
+		row.Invalid = append(row.Invalid, fmt.Sprintf("unknown airflow %q", row.Request.Airflow))
+	}
+
+	for _, col := range slices.Sorted(maps.Keys(cfColumns)) {
+		name, cell := cfColumns[col], row.Cell(col)
+		if cell == "" {
+			continue
+		}
+		field, ok := findCustomField(reg.CustomFields, name)
+		if !ok {
+			row.Invalid = append(row.Invalid, fmt.Sprintf("no custom field %q for devices", name))
+			continue
+		}
+		value, err := field.Parse(cell)
+		if err != nil {
+			row.Invalid = append(row.Invalid, err.Error())
+			continue
+		}
+		if row.Request.CustomFields == nil {
+			row.Request.CustomFields = map[string]interface{}{}
+		}
+		row.Request.CustomFields[field.Name] = value
+	}
+}
+
+// checkImportRows matches every row to the existing devices and works out
+// its action and problems again, e.g. after the user picked an object for
+// an unresolved cell or changed the import mode.
+func checkImportRows(rows []importRow, existing []DeviceDetails, opts importOptions) {
+	idx := newDeviceIndex(existing)
+	seen := map[string]int{}
+
+	for i := range rows {
+		row := &rows[i]
+		row.Problems = nil
+		row.Action, row.Existing, row.Changes = "", 0, nil
+
+		row.Problems = append(row.Problems, row.Invalid...)
+		pending := map[int]bool{}
+		for _, c := range row.Choices {
+			if c.Pick == 0 {
+				row.Problems = append(row.Problems, c.Problem)
+				pending[c.Ref] = true
+			}
+		}
+
+		device, err := idx.Find(row.Request, opts.MatchSerial)
+		switch {
+		case err != nil:
+			row.Problems = append(row.Problems, err.Error())
+		case device != nil && opts.Mode == importCreateOnly:
+			row.Problems = append(row.Problems, fmt.Sprintf("device %q already exists at this site", device.Name))
+		case device != nil:
+			row.Existing = device.ID
+			row.Before = device
+			row.Changes = deviceChanges(row.Request, device)
+			row.Action = actionUpdate
+			if len(row.Changes) == 0 {
+				row.Action = actionUnchanged
+			}
+		case opts.Mode == importUpdateOnly:
+			row.Problems = append(row.Problems, "no existing device to update")
+		default:
+			row.Action = actionCreate
+			for ref, r := range importRefs {
+				if r.Required && *r.ID(&row.Request) == 0 && !pending[ref] {
+					row.Problems = append(row.Problems, "missing "+r.Kind)
+				}
+			}
+			if row.Request.Name == "" {
+				row.Problems = append(row.Problems, "missing device name")
+			}
+			if row.Request.Serial == "" {
+				row.Problems = append(row.Problems, "missing serial number")
+			}
+			// Matched by serial, the name may still be taken
+			if taken, _ := idx.Find(row.Request, false); opts.MatchSerial && taken != nil {
+				row.Problems = append(row.Problems, fmt.Sprintf("device %q already exists at this site", taken.Name))
+			}
+		}
+
+		// Two rows must not create the same device or update the same one
+		key := ""
+		switch {
+		case row.Existing != 0:
+			key = fmt.Sprint("id ", row.Existing)
+		case row.Request.Name != "":
+			key = fmt.Sprint(nameSiteKey(row.Request.Name, row.Request.Site), " ", row.Request.Tenant)
+		}
+		if key != "" {
+			if line, ok := seen[key]; ok {
+				row.Problems = append(row.Problems, fmt.Sprintf("duplicate of row %d", line))
+			} else {
+				seen[key] = row.Line
+			}
+		}
+
+		if !row.Valid() {
+			row.Selected = false
+		}
+	}
+}
+
+// pickImportChoice settles choice c of row i with the candidate the user
+// picked in the preview.
+func pickImportChoice(i, c int) {
+	row := &importPreview[i]
+	choice := &row.Choices[c]
+
+	id := 0
+	if choice.Pick > 0 && int(choice.Pick) <= len(choice.Candidates) {
+		id = choice.Candidates[choice.Pick-1].ID
+	}
+	*choice.target(&row.Request) = id
+
+	wasValid := row.Valid()
+	checkImportRows(importPreview, importExisting, importOpts)
+	if row.Valid() && !wasValid {
+		row.Selected = true
+	}
+}
+
+// Rows sent per bulk request unless the profile or the user says otherwise
+const defaultImportBatchSize = 100
+
+// importResult is the outcome of an import.
+type importResult struct {
+	Rows []importRow `json:"rows"`
+	// Requests is the number of bulk requests sent, including those
+	// that narrowed down failing rows.
+	Requests int           `json:"requests"`
+	Duration time.Duration `json:"duration"`
+	// Journal records what the import changed so it can be rolled back;
+	// nil in dry-run mode.
+	Journal *importJournal `json:"-"`
+}
+
+// Summary counts the rows by outcome and says how long the import took.
+func (r importResult) Summary() string {
+	return fmt.Sprintf("%s in %s (%d requests)", importSummary(r.Rows), r.Duration.Round(time.Millisecond), r.Requests)
+}
+
+// importDevices creates and updates the devices of the selected rows with
+// bulk requests of batchSize devices. NetBox applies a batch entirely or
+// not at all, so a batch that fails validation is split in halves until
+// the bad rows are found and the others written. The rows are returned
+// with their outcome filled in. Unless it is a dry run, every device written
+// is recorded in a journal named after source.
+func importDevices(rows []importRow, batchSize int, source string) importResult {
+	if batchSize < 1 {
+		batchSize = defaultImportBatchSize
+	}
+	result := importResult{Rows: make([]importRow, len(rows))}
+	if !dryRun.Load() {
+		result.Journal = newImportJournal(source)
+	}
+	copy(result.Rows, rows)
+	start := time.Now()
+
+	var creates, matched []*importRow
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		switch {
+		case !row.Valid():
+			row.Outcome, row.Reason = outcomeSkipped, strings.Join(row.Problems, "; ")
+		case !row.Selected:
+			row.Outcome, row.Reason = outcomeSkipped, "deselected"
+		case row.Action == actionUpdate || row.Action == actionUnchanged:
+			matched = append(matched, row)
+		default:
+			creates = append(creates, row)
+		}
+	}
+	updates, requests := rediffDevices(matched, batchSize)
+	result.Requests += requests
+
+	for _, pending := range []struct {
+		method string
+		rows   []*importRow
+	}{{http.MethodPost, creates}, {http.MethodPatch, updates}} {
+		for rows := pending.rows; len(rows) > 0; {
+			n := min(batchSize, len(rows))
+			result.Requests += writeDeviceBatch(pending.method, rows[:n], result.Journal)
+			rows = rows[n:]
+		}
+	}
+
+	objects.Invalidate(kindDevices)
+	result.Duration = time.Since(start)
+	return result
+}
+
+// rediffDevices fetches the devices the rows matched as they are now and
+// works out the changes of the rows again, since the preview may have been
+// built from a snapshot that is minutes old or from the offline cache. It
+// returns the rows that still change something and the number of requests
+// sent.
+func rediffDevices(rows []*importRow, batchSize int) ([]*importRow, int) {
+	var updates []*importRow
+	requests := 0
+	for len(rows) > 0 {
+		n := min(batchSize, len(rows))
+		batch := rows[:n]
+		rows = rows[n:]
+
+		query := url.Values{}
+		for _, row := range batch {
+			query.Add("id", strconv.Itoa(row.Existing))
+		}
+		requests++
+		current, err := netbox.ListAll[DeviceDetails](ctx, nbClient, "/api/dcim/devices/", query, netbox.ListOptions{})
+		if err != nil {
+			logError("fetching the devices to update", err)
+			for _, row := range batch {
+				row.Outcome, row.Reason = outcomeFailed, "fetching the current device: "+err.Error()
+			}
+			continue
+		}
+		byID := make(map[int]*DeviceDetails, len(current))
+		for i := range current {
+			byID[current[i].ID] = &current[i]
+		}
+
+		for _, row := range batch {
+			device, ok := byID[row.Existing]
+			if !ok {
+				row.Outcome, row.Reason = outcomeFailed, fmt.Sprintf("device %d no longer exists", row.Existing)
+				continue
+			}
+			row.Before = device
+			row.Changes = deviceChanges(row.Request, device)
+			if len(row.Changes) == 0 {
+				row.Action, row.Outcome, row.Reason = actionUnchanged, outcomeUnchanged, ""
+				continue
+			}
+			row.Action = actionUpdate
+			updates = append(updates, row)
+		}
+	}
+	return updates, requests
+}
+
+// writeDeviceBatch creates (POST) or updates (PATCH) the devices of batch
+// with one bulk request, bisecting the batch when NetBox rejects it. It
+// fills in the outcome of the rows, records the written ones in j and
+// returns the number of requests sent.
+func writeDeviceBatch(method string, batch []*importRow, j *importJournal) int {
+	var body interface{}
+	outcome := outcomeCreated
+	if method == http.MethodPatch {
+		patches := make([]map[string]interface{}, len(batch))
+		for i, row := range batch {
+			patches[i] = devicePatch(row.Existing, row.Changes)
+		}
+		body, outcome = patches, outcomeUpdated
+	} else {
+		devices := make(deviceBatch, len(batch))
+		for i, row := range batch {
+			devices[i] = row.Request
+			// New devices are active unless the spreadsheet says otherwise
+			if devices[i].Status == "" {
+				devices[i].Status = "active"
+			}
+		}
+		body = devices
+	}
+
+	var written []struct {
+		ID int `json:"id"`
+	}
+	err := write(method, "/api/dcim/devices/", body, &written)
+	if err == nil {
+		if dryRun.Load() {
+			outcome = outcomePlanned
+		}
+		for i, row := range batch {
+			row.Outcome, row.Reason = outcome, ""
+			if i < len(written) {
+				row.ID = written[i].ID
+			}
+		}
+		j.Record(method, batch)
+		return 1
+	}
+
+	// Only validation errors are down to particular rows; anything else
+	// would fail the halves too
+	if len(batch) > 1 && netbox.StatusCode(err) == http.StatusBadRequest {
+		mid := len(batch) / 2
+		return 1 + writeDeviceBatch(method, batch[:mid], j) + writeDeviceBatch(method, batch[mid:], j)
+	}
+
+	for _, row := range batch {
+		row.Outcome, row.Reason = outcomeFailed, err.Error()
+	}
+	verb := "creating"
+	if method == http.MethodPatch {
+		verb = "updating"
+	}
+	if len(batch) == 1 {
+		logError(fmt.Sprintf("%s device %q from row %d", verb, batch[0].Request.Name, batch[0].Line), err)
+	} else {
+		logError(fmt.Sprintf("%s %d devices from rows %d-%d", verb, len(batch), batch[0].Line, batch[len(batch)-1].Line), err)
+	}
+	return 1
+}
+
+// deviceBatch is the body of a bulk device create.
+type deviceBatch []DeviceRequest
+
+// Validate checks every device of the batch.
+func (b deviceBatch) Validate() []string {
+	var problems []string
+	for i, d := range b {
+		for _, p := range d.Validate() {
+			problems = append(problems, fmt.Sprintf("device %d (%s): %s", i+1, d.Name, p))
+		}
+	}
+	return problems
+}
+
+// importSummary counts the rows by outcome.
+func importSummary(rows []importRow) string {
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Outcome]++
+	}
+	written := fmt.Sprintf("%d created, %d updated", counts[outcomeCreated], counts[outcomeUpdated])
+	if counts[outcomePlanned] > 0 {
+		written = fmt.Sprintf("%d planned", counts[outcomePlanned])
+	}
+	return fmt.Sprintf("%s, %d unchanged, %d skipped, %d failed", written, counts[outcomeUnchanged], counts[outcomeSkipped], counts[outcomeFailed])
+}
+
+// exportImportReport writes the outcome of every row to the spreadsheet at
+// path.
+func exportImportReport(rows []importRow, path string) error {
+	f := excel.NewFile()
+	sheetName := "Report"
+	index, _ := f.NewSheet(sheetName)
+	f.DeleteSheet("Sheet1")
+
+	headers := []string{"Row", "Device Name", "Serial Number", "Outcome", "Reason", "Changes"}
+	for i, header := range headers {
+		cell, _ := excel.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, header)
+	}
+
+	reg := currentRegistry()
+	for rowIndex, row := range rows {
+		line := rowIndex + 2 // Start from the second row
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", line), row.Line)
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", line), row.Request.Name)
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", line), row.Request.Serial)
+		f.SetCellValue(sheetName, fmt.Sprintf("D%d", line), row.Outcome)
+		f.SetCellValue(sheetName, fmt.Sprintf("E%d", line), row.Reason)
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", line), describeChanges(row.Changes, reg))
+	}
+
+	f.SetActiveSheet(index)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return f.SaveAs(path)
+}
+
+// State of the import window. Everything here is only touched on the render
+// loop.
+var showImportWindow bool
+var importPath = "DeviceToImport.xlsx"
+var importTemplateName string
+var importData *importTable
+var importColumns columnMapping
+var importSheetChoice int32
+var importColumnChoice []int32 // per field: the column plus one, 0 unmapped
+var importPreview []importRow
+var importExisting []DeviceDetails
+var importRunning bool
+var importBatchSize int32
+var importOpts = importOptions{Mode: importCreateOnly}
+var importModeChoice int32
+var importTiming string
+var importJournalDone *importJournal
+
+// importDeviceFromCSV shows the import window, reading the import file the
+// first time and resolving its rows again against the current reference
+// data. Nothing is created until the rows have been reviewed.
+func importDeviceFromCSV() {
+	showImportWindow = true
+	if deviceTable == nil {
+		dataSvc.Refresh(deviceScreen, true)
+	}
+	if importData == nil {
+		openImportFile("")
+		return
+	}
+	if !importDone() {
+		previewImport()
+	}
+}
+
+// importDone reports whether the preview holds the outcome of an import
+// rather than rows still to review.
+func importDone() bool {
+	for _, row := range importPreview {
+		if row.Outcome != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshImportPreview resolves the rows of an open, not yet imported file
+// again after the reference data changed, so no ID of an older registry is
+// sent.
+func refreshImportPreview() {
+	if showImportWindow && importData != nil && !importRunning && !importDone() {
+		previewImport()
+	}
+}
+
+// resetImportWindow forgets the import file and everything resolved from
+// it, e.g. when switching to another NetBox instance.
+func resetImportWindow() {
+	showImportWindow = false
+	importData = nil
+	importColumns = nil
+	importColumnChoice = nil
+	importSheetChoice = 0
+	importPreview = nil
+	importExisting = nil
+	importTiming = ""
+	importJournalDone = nil
+	showHistoryWindow = false
+	importHistory = nil
+}
+
+// openImportFile reads importPath, from the given sheet of a workbook, and
+// maps its columns with the template named after the file, if saved.
+func openImportFile(sheet string) {
+	if importTemplateName == "" {
+		importTemplateName = templateName(importPath)
+	}
+	table, m, err := openImport(importPath, sheet, importTemplateName)
+	if err != nil {
+		reportError("reading "+importPath, err)
+		return
+	}
+
+	importData = table
+	importColumns = m
+	if importBatchSize < 1 {
+		importBatchSize = int32(activeProfile.importBatchSize())
+	}
+	importSheetChoice = 0
+	for i, name := range table.Sheets {
+		if name == table.Sheet {
+			importSheetChoice = int32(i)
+		}
+	}
+	importColumnChoice = make([]int32, len(m))
+	for i, col := range m {
+		importColumnChoice[i] = int32(col + 1)
+	}
+	previewImport()
+}
+
+// previewImport resolves the rows of the import file with the current
+// column mapping.
+func previewImport() {
+	if importData == nil {
+		importPreview = nil
+		return
+	}
+	importExisting = nil
+	importJournalDone = nil
+	if deviceTable != nil {
+		importExisting = deviceTable.Devices
+	}
+	rows := resolveImportRows(importData, importColumns, currentRegistry(), importExisting, importOpts)
+	keepImportPicks(rows, importPreview)
+	importPreview = rows
+}
+
+// keepImportPicks carries the objects the user picked and the rows they
+// deselected in the previous preview over to rows, matching picks by ID so
+// nothing resolved against an older registry survives.
+func keepImportPicks(rows, previous []importRow) {
+	byLine := make(map[int]*importRow, len(previous))
+	for i := range previous {
+		byLine[previous[i].Line] = &previous[i]
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		old, ok := byLine[row.Line]
+		if !ok {
+			continue
+		}
+		for c := range row.Choices {
+			choice := &row.Choices[c]
+			for _, oc := range old.Choices {
+				if oc.Ref != choice.Ref || oc.Slot != choice.Slot || oc.Value != choice.Value || oc.Pick == 0 || int(oc.Pick) > len(oc.Candidates) {
+					continue
+				}
+				picked := oc.Candidates[oc.Pick-1].ID
+				for k, candidate := range choice.Candidates {
+					if candidate.ID == picked {
+						choice.Pick = int32(k + 1)
+						*choice.target(&row.Request) = picked
+					}
+				}
+			}
+		}
+		row.Selected = old.Selected || !old.Valid()
+	}
+
+	checkImportRows(rows, importExisting, importOpts)
+}
+
+// mapImportColumn applies the column combo of field i.
+func mapImportColumn(i int) {
+	importColumns[i] = int(importColumnChoice[i]) - 1
+	previewImport()
+}
+
+// saveImportMapping saves the column mapping under the template name, so
+// the next file of the same template is mapped the same way.
+func saveImportMapping() {
+	if importData == nil || importData.Header == nil {
+		return
+	}
+	t := newImportTemplate(importColumns, importData.Header, importData.Sheet)
+	if err := saveImportTemplate(importTemplateName, t); err != nil {
+		reportError("saving import template", err)
+		return
+	}
+	logInfo("Column mapping saved as template %s", importTemplateName)
+}
+
+// importColumnNames lists the columns of the import file for the mapping
+// combos, by header or by spreadsheet column letter.
+func importColumnNames() []string {
+	names := []string{"(not mapped)"}
+	if importData == nil {
+		return names
+	}
+	columns := len(importData.Header)
+	for _, row := range importData.Rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	for col := 0; col < columns; col++ {
+		name := ""
+		if col < len(importData.Header) {
+			name = strings.TrimSpace(importData.Header[col])
+		}
+		if name == "" {
+			name, _ = excel.ColumnNumberToName(col + 1)
+			name = "Column " + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// importMappingWidgets are the file, sheet and column mapping controls of
+// the import window.
+func importMappingWidgets() imgui.Layout {
+	fileRow := []imgui.Widget{
+		imgui.InputText(&importPath).Label("File (.xlsx, .csv, .tsv)").Size(300),
+		imgui.Button("Open").OnClick(func() {
+			importTemplateName = templateName(importPath)
+			openImportFile("")
+		}),
+	}
+	if importData != nil && len(importData.Sheets) > 1 {
+		fileRow = append(fileRow,
+			imgui.Combo("Sheet", importData.Sheets[importSheetChoice], importData.Sheets, &importSheetChoice).Size(150).OnChange(func() {
+				openImportFile(importData.Sheets[importSheetChoice])
+			}),
+		)
+	}
+
+	columns := importColumnNames()
+	var combos []imgui.Widget
+	for i, field := range importFields {
+		if i >= len(importColumnChoice) {
+			break
+		}
+		if int(importColumnChoice[i]) >= len(columns) {
+			importColumnChoice[i] = 0
+		}
+		i := i
+		combos = append(combos,
+			imgui.Combo(field.Label, columns[importColumnChoice[i]], columns, &importColumnChoice[i]).Size(250).OnChange(func() {
+				mapImportColumn(i)
+			}),
+		)
+	}
+
+	return imgui.Layout{
+		imgui.Row(fileRow...),
+		imgui.TreeNode("Column Mapping").Layout(
+			imgui.Row(
+				imgui.InputText(&importTemplateName).Label("Template").Size(200),
+				imgui.Button("Save Mapping").Disabled(importData == nil || importData.Header == nil).OnClick(saveImportMapping),
+			),
+			imgui.Layout(combos),
+		),
+	}
+}
+
+// startImport creates the selected rows of the preview in the background.
+func startImport() {
+	rows := make([]importRow, len(importPreview))
+	copy(rows, importPreview)
+	batchSize := int(importBatchSize)
+	path := importPath
+	importRunning = true
+
+	dataSvc.Go(func() {
+		result := importDevices(rows, batchSize, filepath.Base(path))
+		logWrite("Import finished: %s", result.Summary())
+
+		dataSvc.Post(func() {
+			importPreview = result.Rows
+			importJournalDone = result.Journal
+			importTiming = result.Summary()
+			importRunning = false
+			requestRefresh()
+		})
+	})
+}
+
+// rackPlacement shows the rack of d with its position and face.
+func rackPlacement(d DeviceRequest, reg *registry) string {
+	if d.Rack == 0 {
+		return "-"
+	}
+	s := refName(reg.Racks, d.Rack, "?")
+	if d.Position != 0 {
+		s += fmt.Sprintf(" U%g", d.Position)
+	}
+	if d.Face != "" {
+		s += " " + d.Face
+	}
+	return s
+}
+
+// deviceExtras shows the status, asset tag, airflow, tags and custom
+// fields of d.
+func deviceExtras(d DeviceRequest, reg *registry) string {
+	var parts []string
+	if d.Status != "" {
+		parts = append(parts, "status "+d.Status)
+	}
+	if d.AssetTag != "" {
+		parts = append(parts, "asset tag "+d.AssetTag)
+	}
+	if d.Airflow != "" {
+		parts = append(parts, "airflow "+d.Airflow)
+	}
+	if len(d.Tags) > 0 {
+		names := make([]string, len(d.Tags))
+		for i, id := range d.Tags {
+			names[i] = refName(reg.Tags, id, "?")
+		}
+		parts = append(parts, "tags "+strings.Join(names, ", "))
+	}
+	for _, name := range slices.Sorted(maps.Keys(d.CustomFields)) {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, d.CustomFields[name]))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, "\n")
+}
+
+// setImportMode applies the mode combo and matches the rows again.
+func setImportMode() {
+	importOpts.Mode = importModes[importModeChoice]
+	previewImport()
+}
+
+// selectImportRows selects every valid row, or none.
+func selectImportRows(selected bool) {
+	for i := range importPreview {
+		importPreview[i].Selected = selected && importPreview[i].Valid()
+	}
+}
+
+func saveImportReport() {
+	path := exportPath("import_report.xlsx")
+	if err := exportImportReport(importPreview, path); err != nil {
+		reportError("saving import report", err)
+		return
+	}
+	logInfo("Import report saved to %s", path)
+}
+
+func importWindow() {
+	if !showImportWindow {
+		return
+	}
+
+	reg := currentRegistry()
+	imported := false
+	selected := 0
+	rows := make([]*imgui.TableRowWidget, 0, len(importPreview))
+	for i := range importPreview {
+		row := &importPreview[i]
+		imported = imported || row.Outcome != ""
+		if row.Selected {
+			selected++
+		}
+
+		status := row.Action
+		switch {
+		case row.Outcome != "":
+			status = row.Outcome
+			if row.Reason != "" {
+				status += ": " + row.Reason
+			}
+		case !row.Valid():
+			status = strings.Join(row.Problems, "\n")
+		case row.Action == actionUpdate:
+			status += ": " + describeChanges(row.Changes, reg)
+		}
+		statusCell := []imgui.Widget{imgui.Label(status).Wrapped(true)}
+
+		// Cells that matched several objects, or none, get a combo to pick
+		// the right one instead of a guess
+		if !imported {
+			for c := range row.Choices {
+				choice := &row.Choices[c]
+				items := []string{fmt.Sprintf("Pick %s for %q", importRefs[choice.Ref].Kind, choice.Value)}
+				for _, candidate := range choice.Candidates {
+					items = append(items, candidate.Name)
+				}
+				if len(items) == 1 {
+					continue
+				}
+				ri, ci := i, c
+				statusCell = append(statusCell,
+					imgui.Combo(fmt.Sprintf("##choice%d-%d", i, c), items[choice.Pick], items, &choice.Pick).Size(250).OnChange(func() {
+						pickImportChoice(ri, ci)
+					}),
+				)
+			}
+		}
+
+		// Resolved names are shown with their IDs so mismatches stand out
+		ref := func(l *refList, id int) string {
+			if id == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%s (%d)", refName(l, id, "?"), id)
+		}
+
+		// Rows that cannot be imported, and all rows once imported, are
+		// not selectable
+		var pick imgui.Widget = imgui.Checkbox(fmt.Sprintf("##import%d", i), &row.Selected)
+		if !row.Valid() || imported {
+			pick = imgui.Label("-")
+		}
+
+		rows = append(rows, imgui.TableRow(
+			pick,
+			imgui.Label(fmt.Sprint(row.Line)),
+			imgui.Label(row.Request.Name),
+			imgui.Label(row.Request.Serial),
+			imgui.Label(ref(reg.Tenants, row.Request.Tenant)),
+			imgui.Label(ref(reg.Manufacturers, row.Request.Manufacturer)),
+			imgui.Label(ref(reg.DeviceRoles, row.Request.DeviceRole)),
+			imgui.Label(ref(reg.Sites, row.Request.Site)),
+			imgui.Label(ref(reg.DeviceTypes, row.Request.DeviceType)),
+			imgui.Label(ref(reg.Locations, row.Request.Location)),
+			imgui.Label(rackPlacement(row.Request, reg)),
+			imgui.Label(ref(reg.Platforms, row.Request.Platform)),
+			imgui.Label(deviceExtras(row.Request, reg)).Wrapped(true),
+			imgui.Column(statusCell...),
+		))
+	}
+
+	summary := fmt.Sprintf("%d rows, %d selected", len(importPreview), selected)
+	// Without the device list no row can match an existing device, and
+	// every row to update would be created instead
+	waiting := importOpts.Mode != importCreateOnly && deviceTable == nil
+	if waiting {
+		summary = "Waiting for the device list to match existing devices..."
+	}
+	if imported {
+		summary = importTiming
+	}
+
+	imgui.Window("Import Devices").IsOpen(&showImportWindow).Size(1400, 550).Layout(
+		importMappingWidgets(),
+		imgui.Row(
+			imgui.Button("Select All Valid").Disabled(imported).OnClick(func() { selectImportRows(true) }),
+			imgui.Button("Select None").Disabled(imported).OnClick(func() { selectImportRows(false) }),
+			imgui.Combo("Mode", importModeLabels[importModeChoice], importModeLabels, &importModeChoice).Size(150).OnChange(setImportMode),
+			imgui.Checkbox("Match by serial", &importOpts.MatchSerial).OnChange(previewImport),
+			imgui.InputInt(&importBatchSize).Label("Batch size").Size(80),
+			imgui.Button("Import Selected").Disabled(imported || importRunning || waiting || selected == 0 || writesDisabled()).OnClick(startImport),
+			imgui.Button("Export Report").Disabled(!imported).OnClick(saveImportReport),
+			imgui.Button("Roll Back This Import").Disabled(importJournalDone == nil || len(importJournalDone.Entries) == 0 || importJournalDone.RolledBack != nil || writesDisabled()).OnClick(func() {
+				confirmRollback(importJournalDone)
+			}),
+			imgui.Button("History").OnClick(showImportHistory),
+			imgui.Label(summary),
+		),
+		imgui.Table().Freeze(0, 1).Columns(
+			imgui.TableColumn("").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(30),
+			imgui.TableColumn("Row").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(40),
+			imgui.TableColumn("Name"),
+			imgui.TableColumn("Serial"),
+			imgui.TableColumn("Tenant"),
+			imgui.TableColumn("Manufacturer"),
+			imgui.TableColumn("Role"),
+			imgui.TableColumn("Site"),
+			imgui.TableColumn("Type"),
+			imgui.TableColumn("Location"),
+			imgui.TableColumn("Rack"),
+			imgui.TableColumn("Platform"),
+			imgui.TableColumn("Other"),
+			imgui.TableColumn("Status"),
+		).Rows(rows...),
+	)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"main/netbox-data-app/netbox"
+)
+
+// deviceServer accepts bulk device creates, rejecting with 400 every batch
+// that holds a device named "bad..." and answering status otherwise when it
+// is not 0. It counts the requests in requests.
+func deviceServer(t *testing.T, status int, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+	var nextID atomic.Int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var devices []DeviceRequest
+		if err := json.NewDecoder(r.Body).Decode(&devices); err != nil {
+			t.Errorf("decoding bulk create: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status != 0 {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"detail": "server error"}`))
+			return
+		}
+		for _, d := range devices {
+			if strings.HasPrefix(d.Name, "bad") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"name": ["invalid"]}`))
+				return
+			}
+		}
+
+		created := make([]map[string]int, len(devices))
+		for i := range devices {
+			created[i] = map[string]int{"id": int(nextID.Add(1))}
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}))
+}
+
+func TestWriteDeviceBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		names        []string
+		status       int
+		wantRequests int
+		want         []string
+	}{
+		{"one request", []string{"a", "b", "c", "d"}, 0, 1,
+			[]string{outcomeCreated, outcomeCreated, outcomeCreated, outcomeCreated}},
+		{"bisected to the rejected row", []string{"a", "b", "bad", "d"}, 0, 5,
+			[]string{outcomeCreated, outcomeCreated, outcomeFailed, outcomeCreated}},
+		{"two rejected rows", []string{"bad1", "b", "c", "bad2"}, 0, 7,
+			[]string{outcomeFailed, outcomeCreated, outcomeCreated, outcomeFailed}},
+		{"server errors are not bisected", []string{"a", "b", "c", "d"}, http.StatusInternalServerError, 1,
+			[]string{outcomeFailed, outcomeFailed, outcomeFailed, outcomeFailed}},
+	}
+
+	ctx = context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := deviceServer(t, tt.status, &requests)
+			defer srv.Close()
+			nbClient = netbox.NewClient(srv.URL, "token", netbox.WithRetryPolicy(netbox.RetryPolicy{MaxAttempts: 1}))
+
+			batch := make([]*importRow, len(tt.names))
+			for i, name := range tt.names {
+				batch[i] = &importRow{Line: i + 2, Request: DeviceRequest{Name: name}}
+			}
+			sent := writeDeviceBatch(http.MethodPost, batch, nil)
+
+			if sent != tt.wantRequests || int(requests.Load()) != tt.wantRequests {
+				t.Errorf("sent %d requests, server saw %d, want %d", sent, requests.Load(), tt.wantRequests)
+			}
+			for i, row := range batch {
+				if row.Outcome != tt.want[i] {
+					t.Errorf("row %d outcome = %q (%s), want %q", row.Line, row.Outcome, row.Reason, tt.want[i])
+				}
+				if (row.Outcome == outcomeCreated) != (row.ID != 0) {
+					t.Errorf("row %d: outcome %q with ID %d", row.Line, row.Outcome, row.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	excel "github.com/xuri/excelize/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Fields of DeviceRequest a spreadsheet column can be mapped to
+const (
+	fieldName = iota
+	fieldSerial
+	fieldTenant
+	fieldManufacturer
+	fieldRole
+	fieldSite
+	fieldType
+	fieldLocation
+	fieldRack
+	fieldPosition
+	fieldFace
+	fieldPlatform
+	fieldAssetTag
+	fieldAirflow
+	fieldTags
+	fieldStatus
+)
+
+// importField is a device field the importer fills from a column.
+type importField struct {
+	// Key names the field in templates and on the command line.
+	Key   string
+	Label string
+	// Aliases are the headers recognised without a template, compared
+	// ignoring case, spaces, dashes and underscores.
+	Aliases []string
+}
+
+var importFields = []importField{
+	fieldName:         {"name", "Device Name", []string{"devicename", "name", "device", "hostname"}},
+	fieldSerial:       {"serial", "Serial Number", []string{"serialnumber", "serial", "sn"}},
+	fieldTenant:       {"tenant", "Tenant", []string{"tenant", "tenants"}},
+	fieldManufacturer: {"manufacturer", "Manufacturer", []string{"manufacturer", "vendor"}},
+	fieldRole:         {"role", "Device Role", []string{"devicerole", "role"}},
+	fieldSite:         {"site", "Site", []string{"devicesite", "site"}},
+	fieldType:         {"device_type", "Device Type", []string{"devicetype", "type", "model"}},
+	fieldLocation:     {"location", "Location", []string{"location", "room"}},
+	fieldRack:         {"rack", "Rack", []string{"rack", "rackname"}},
+	fieldPosition:     {"position", "Position (U)", []string{"position", "u", "rackposition", "rackunit"}},
+	fieldFace:         {"face", "Rack Face", []string{"face", "rackface"}},
+	fieldPlatform:     {"platform", "Platform", []string{"platform", "os"}},
+	fieldAssetTag:     {"asset_tag", "Asset Tag", []string{"assettag", "asset"}},
+	fieldAirflow:      {"airflow", "Airflow", []string{"airflow"}},
+	fieldTags:         {"tags", "Tags", []string{"tags", "tag"}},
+	fieldStatus:       {"status", "Status", []string{"status", "devicestatus"}},
+}
+
+// Columns headed cf_<name> fill the custom field <name>, as in NetBox's own
+// CSV import.
+const customFieldPrefix = "cf_"
+
+// customFieldColumns returns the custom field named by each cf_ column of
+// header, by column.
+func customFieldColumns(header []string) map[int]string {
+	columns := map[int]string{}
+	for col, h := range header {
+		h = strings.TrimSpace(h)
+		if len(h) > len(customFieldPrefix) && strings.EqualFold(h[:len(customFieldPrefix)], customFieldPrefix) {
+			columns[col] = h[len(customFieldPrefix):]
+		}
+	}
+	return columns
+}
+
+// columnMapping holds the column of every import field, -1 when the field
+// is not mapped.
+type columnMapping []int
+
+// legacyMapping is the fixed layout of spreadsheets without a header row,
+// which only carry the fields up to the device type.
+func legacyMapping() columnMapping {
+	m := make(columnMapping, len(importFields))
+	for i := range m {
+		m[i] = -1
+		if i <= fieldType {
+			m[i] = i
+		}
+	}
+	return m
+}
+
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '_', '\t':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(h)))
+}
+
+// detectMapping maps the fields to the columns of header by their aliases.
+func detectMapping(header []string) columnMapping {
+	m := make(columnMapping, len(importFields))
+	for i, field := range importFields {
+		m[i] = -1
+		for _, alias := range field.Aliases {
+			if col := headerColumn(header, alias); col >= 0 {
+				m[i] = col
+				break
+			}
+		}
+	}
+	return m
+}
+
+// headerColumn returns the column of header called name, or -1.
+func headerColumn(header []string, name string) int {
+	name = normalizeHeader(name)
+	for col, h := range header {
+		if normalizeHeader(h) == name {
+			return col
+		}
+	}
+	return -1
+}
+
+// Mapped reports whether any field is mapped.
+func (m columnMapping) Mapped() bool {
+	for _, col := range m {
+		if col >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Set maps the field with the given key to the column headed name.
+func (m columnMapping) Set(header []string, key, name string) error {
+	for i, field := range importFields {
+		if field.Key != key {
+			continue
+		}
+		col := headerColumn(header, name)
+		if col < 0 {
+			return fmt.Errorf("no column %q for %s", name, key)
+		}
+		m[i] = col
+		return nil
+	}
+	return fmt.Errorf("unknown field %q", key)
+}
+
+// importTable is the contents of an import file.
+type importTable struct {
+	// Sheets lists the sheets of a workbook; empty for CSV and TSV.
+	Sheets []string
+	Sheet  string
+	// Header is nil for files without a header row.
+	Header []string
+	Rows   [][]string
+	// FirstLine is the line of Rows[0] in the file, starting at 1.
+	FirstLine int
+}
+
+// readImportFile reads the import file at path. sheet picks the sheet of a
+// workbook; the active sheet is read when it is empty.
+func readImportFile(path, sheet string) (*importTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readImportData(f, filepath.Base(path), sheet)
+}
+
+// readImportData reads an import file from r. The format is picked by the
+// extension of name: .xlsx, .csv or .tsv.
+func readImportData(r io.Reader, name, sheet string) (*importTable, error) {
+	var table *importTable
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".xlsx", ".xlsm":
+		table, err = readWorkbook(r, sheet)
+	case ".csv", ".tsv", ".txt":
+		table, err = readDelimited(r, ext == ".tsv")
+	default:
+		return nil, fmt.Errorf("%s: unsupported file type %q, use .xlsx, .csv or .tsv", name, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	// The first row is the header unless none of it looks like one
+	table.FirstLine = 1
+	if len(table.Rows) > 0 && detectMapping(table.Rows[0]).Mapped() {
+		table.Header = table.Rows[0]
+		table.Rows = table.Rows[1:]
+		table.FirstLine = 2
+	}
+	return table, nil
+}
+
+func readWorkbook(r io.Reader, sheet string) (*importTable, error) {
+	f, err := excel.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			logError("closing spreadsheet", err)
+		}
+	}()
+
+	table := &importTable{Sheets: f.GetSheetList(), Sheet: sheet}
+	if table.Sheet == "" {
+		table.Sheet = f.GetSheetName(f.GetActiveSheetIndex())
+	}
+	table.Rows, err = f.GetRows(table.Sheet)
+	return table, err
+}
+
+func readDelimited(r io.Reader, tabs bool) (*importTable, error) {
+	// Excel's "CSV UTF-8" starts the file with a byte order mark, which
+	// would otherwise stick to the first header
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\ufeff" {
+		br.Discard(len(bom))
+	}
+
+	reader := csv.NewReader(br)
+	if tabs {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return &importTable{Rows: rows}, nil
+}
+
+// importTemplate is a saved column mapping. Columns maps field keys to
+// header names, so the mapping still works when columns move.
+type importTemplate struct {
+	Sheet   string            `yaml:"sheet,omitempty"`
+	Columns map[string]string `yaml:"columns"`
+}
+
+// Mapping applies the template to header. Fields whose column is missing
+// are left unmapped and returned.
+func (t importTemplate) Mapping(header []string) (columnMapping, []string) {
+	m := make(columnMapping, len(importFields))
+	var missing []string
+	for i, field := range importFields {
+		m[i] = -1
+		name, ok := t.Columns[field.Key]
+		if !ok {
+			continue
+		}
+		if m[i] = headerColumn(header, name); m[i] < 0 {
+			missing = append(missing, name)
+		}
+	}
+	return m, missing
+}
+
+// newImportTemplate records mapping m over header as a template.
+func newImportTemplate(m columnMapping, header []string, sheet string) importTemplate {
+	t := importTemplate{Sheet: sheet, Columns: map[string]string{}}
+	for i, col := range m {
+		if col >= 0 && col < len(header) {
+			t.Columns[importFields[i].Key] = header[col]
+		}
+	}
+	return t
+}
+
+// templateName is the template an import file uses unless told otherwise:
+// its name without the extension.
+func templateName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// importTemplatesPath returns where column mappings are saved.
+func importTemplatesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "netbox-data-app", "import-templates.yaml"), nil
+}
+
+// loadImportTemplates reads the saved column mappings. A missing file
+// yields none.
+func loadImportTemplates() (map[string]importTemplate, error) {
+	path, err := importTemplatesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]importTemplate{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Templates map[string]importTemplate `yaml:"templates"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if file.Templates == nil {
+		file.Templates = map[string]importTemplate{}
+	}
+	return file.Templates, nil
+}
+
+// saveImportTemplate stores t under name, keeping the other templates.
+func saveImportTemplate(name string, t importTemplate) error {
+	templates, err := loadImportTemplates()
+	if err != nil {
+		return err
+	}
+	templates[name] = t
+
+	data, err := yaml.Marshal(struct {
+		Templates map[string]importTemplate `yaml:"templates"`
+	}{templates})
+	if err != nil {
+		return err
+	}
+	path, err := importTemplatesPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// openImport reads the import file at path and picks its column mapping:
+// the saved template called name when there is one, else the headers, else
+// the fixed layout of files without a header row. The template also picks
+// the sheet unless sheet is set. A template whose columns are missing is
+// applied as far as it goes.
+func openImport(path, sheet, name string) (*importTable, columnMapping, error) {
+	t, ok := findImportTemplate(name)
+	if sheet == "" && ok {
+		sheet = t.Sheet
+	}
+
+	table, err := readImportFile(path, sheet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return table, table.mapping(t, ok), nil
+}
+
+// findImportTemplate returns the saved template called name.
+func findImportTemplate(name string) (importTemplate, bool) {
+	templates, err := loadImportTemplates()
+	if err != nil {
+		logError("loading import templates", err)
+	}
+	t, ok := templates[name]
+	return t, ok
+}
+
+// mapping applies template t when ok, else detects the mapping.
+func (table *importTable) mapping(t importTemplate, ok bool) columnMapping {
+	if table.Header == nil {
+		return legacyMapping()
+	}
+	if !ok {
+		return detectMapping(table.Header)
+	}
+	m, missing := t.Mapping(table.Header)
+	if len(missing) > 0 {
+		logInfo("Import template columns not found: %s", strings.Join(missing, ", "))
+	}
+	return m
+}
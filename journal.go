@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"main/netbox-data-app/netbox"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// What an import did to an object
+const (
+	journalCreated = "created"
+	journalUpdated = "updated"
+)
+
+// journalEntry is an object an import created or updated.
+type journalEntry struct {
+	Action string `json:"action"`
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	// Line is the spreadsheet row the object came from.
+	Line int `json:"line"`
+	// Restore holds the fields an update changed, with their values from
+	// before the import.
+	Restore    map[string]interface{} `json:"restore,omitempty"`
+	RolledBack bool                   `json:"rolled_back,omitempty"`
+}
+
+// importJournal records what an import changed in NetBox so it can be
+// rolled back. Journals are saved after every batch, so an import that
+// stopped half-way can still be undone.
+type importJournal struct {
+	ID      string         `json:"id"`
+	Domain  string         `json:"domain"`
+	Source  string         `json:"source"`
+	Started time.Time      `json:"started"`
+	Entries []journalEntry `json:"entries"`
+	// RolledBack is when the import was rolled back, nil until then.
+	RolledBack *time.Time `json:"rolled_back,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Fields that are cleared with null rather than a zero value
+var journalNullable = map[string]bool{
+	"tenant": true, "device_type": true, "role": true, "site": true,
+	"location": true, "rack": true, "platform": true, "position": true,
+	"asset_tag": true,
+}
+
+// newImportJournal starts the journal of an import from source.
+func newImportJournal(source string) *importJournal {
+	now := time.Now()
+	return &importJournal{
+		ID:      now.Format("20060102-150405.000"),
+		Domain:  nbClient.BaseURL(),
+		Source:  source,
+		Started: now,
+	}
+}
+
+// Record adds the rows of a batch that was written with method and saves
+// the journal. The values updates restore are taken from the devices as
+// fetched right before the write, never from the preview, so a rollback
+// does not undo edits made in NetBox since.
+func (j *importJournal) Record(method string, batch []*importRow) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	for _, row := range batch {
+		entry := journalEntry{ID: row.ID, Name: row.Request.Name, Line: row.Line, Action: journalCreated}
+		if method == http.MethodPatch {
+			if row.Before == nil {
+				logInfo("Not journaled: no prior state of device %d from row %d", row.Existing, row.Line)
+				continue
+			}
+			entry.Action, entry.ID = journalUpdated, row.Existing
+			entry.Restore = map[string]interface{}{}
+			for _, c := range deviceChanges(row.Request, row.Before) {
+				old := c.Old
+				switch c.Old {
+				case 0, 0.0, "":
+					if journalNullable[c.Field] {
+						old = nil
+					}
+				}
+				entry.Restore[c.Field] = old
+			}
+		}
+		if entry.ID != 0 {
+			j.Entries = append(j.Entries, entry)
+		}
+	}
+	j.mu.Unlock()
+
+	if err := j.Save(); err != nil {
+		logError("saving import journal", err)
+	}
+}
+
+// Summary describes the journal for lists.
+func (j *importJournal) Summary() string {
+	created, updated := 0, 0
+	for _, e := range j.Entries {
+		if e.Action == journalCreated {
+			created++
+		} else {
+			updated++
+		}
+	}
+	s := fmt.Sprintf("%s %s: %d created, %d updated", j.Started.Format("2006-01-02 15:04:05"), j.Source, created, updated)
+	if j.RolledBack != nil {
+		s += ", rolled back " + j.RolledBack.Format("2006-01-02 15:04:05")
+	}
+	return s
+}
+
+// journalDir returns where import journals are kept.
+func journalDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "netbox-data-app", "journals"), nil
+}
+
+// Save writes the journal to the journal directory.
+func (j *importJournal) Save() error {
+	j.mu.Lock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dir, err := journalDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, j.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadJournal reads the journal with the given ID.
+func loadJournal(id string) (*importJournal, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	j := &importJournal{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("reading journal %s: %w", id, err)
+	}
+	return j, nil
+}
+
+// listJournals returns the journals of imports into the NetBox at domain,
+// newest first.
+func listJournals(domain string) ([]*importJournal, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []*importJournal
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		j, err := loadJournal(id)
+		if err != nil {
+			logError("reading import journal", err)
+			continue
+		}
+		if j.Domain == domain {
+			journals = append(journals, j)
+		}
+	}
+	sort.Slice(journals, func(a, b int) bool {
+		return journals[a].Started.After(journals[b].Started)
+	})
+	return journals, nil
+}
+
+// Objects removed per bulk delete during a rollback
+const rollbackBatchSize = 100
+
+// rollbackImport undoes the import recorded in j: the devices it created
+// are deleted and the fields it updated get their old values back. Entries
+// already rolled back are skipped, so a rollback that failed half-way can
+// be run again. In dry-run mode the requests are only planned.
+func rollbackImport(j *importJournal) error {
+	if j.Domain != nbClient.BaseURL() {
+		return fmt.Errorf("journal %s belongs to %s, not %s", j.ID, j.Domain, nbClient.BaseURL())
+	}
+
+	var deletes, restores []*journalEntry
+	for i := range j.Entries {
+		e := &j.Entries[i]
+		switch {
+		case e.RolledBack:
+		case e.Action == journalCreated:
+			deletes = append(deletes, e)
+		default:
+			restores = append(restores, e)
+		}
+	}
+
+	// Deleting first frees the names the restored devices may need
+	var errs []error
+	for len(deletes) > 0 {
+		n := min(rollbackBatchSize, len(deletes))
+		errs = append(errs, rollbackDeletes(deletes[:n]))
+		deletes = deletes[n:]
+	}
+	for len(restores) > 0 {
+		n := min(rollbackBatchSize, len(restores))
+		errs = append(errs, rollbackRestores(restores[:n]))
+		restores = restores[n:]
+	}
+	objects.Invalidate(kindDevices)
+
+	if dryRun.Load() {
+		return errors.Join(errs...)
+	}
+	err := errors.Join(errs...)
+	if err == nil {
+		now := time.Now()
+		j.RolledBack = &now
+	}
+	if serr := j.Save(); serr != nil {
+		logError("saving import journal", serr)
+	}
+	return err
+}
+
+// rollbackDeletes deletes the created devices of entries, one by one when
+// the bulk delete fails. Devices that are already gone count as deleted.
+func rollbackDeletes(entries []*journalEntry) error {
+	body := make([]map[string]int, len(entries))
+	for i, e := range entries {
+		body[i] = map[string]int{"id": e.ID}
+	}
+	if err := write(http.MethodDelete, "/api/dcim/devices/", body, nil); err == nil {
+		markRolledBack(entries)
+		return nil
+	}
+
+	var errs []error
+	for _, e := range entries {
+		err := write(http.MethodDelete, fmt.Sprintf("/api/dcim/devices/%d/", e.ID), nil, nil)
+		if err != nil && netbox.StatusCode(err) != http.StatusNotFound {
+			errs = append(errs, fmt.Errorf("deleting device %q (%d): %w", e.Name, e.ID, err))
+			continue
+		}
+		markRolledBack([]*journalEntry{e})
+	}
+	return errors.Join(errs...)
+}
+
+// rollbackRestores patches the updated devices of entries back to their old
+// values, one by one when the bulk update fails.
+func rollbackRestores(entries []*journalEntry) error {
+	patch := func(e *journalEntry) map[string]interface{} {
+		p := map[string]interface{}{"id": e.ID}
+		for field, value := range e.Restore {
+			p[field] = value
+		}
+		return p
+	}
+
+	body := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		body[i] = patch(e)
+	}
+	if err := write(http.MethodPatch, "/api/dcim/devices/", body, nil); err == nil {
+		markRolledBack(entries)
+		return nil
+	}
+
+	var errs []error
+	for _, e := range entries {
+		p := patch(e)
+		delete(p, "id")
+		if err := write(http.MethodPatch, fmt.Sprintf("/api/dcim/devices/%d/", e.ID), p, nil); err != nil {
+			errs = append(errs, fmt.Errorf("restoring device %q (%d): %w", e.Name, e.ID, err))
+			continue
+		}
+		markRolledBack([]*journalEntry{e})
+	}
+	return errors.Join(errs...)
+}
+
+func markRolledBack(entries []*journalEntry) {
+	if dryRun.Load() {
+		return
+	}
+	for _, e := range entries {
+		e.RolledBack = true
+	}
+}
+
+// State of the import history window, only touched on the render loop
+var showHistoryWindow bool
+var importHistory []*importJournal
+
+// showImportHistory opens the import history window with the journals of
+// the NetBox in use.
+func showImportHistory() {
+	journals, err := listJournals(nbClient.BaseURL())
+	if err != nil {
+		reportError("listing import journals", err)
+		return
+	}
+	importHistory = journals
+	showHistoryWindow = true
+}
+
+// confirmRollback asks before rolling back the import recorded in j.
+func confirmRollback(j *importJournal) {
+	question := fmt.Sprintf("Roll back the import of %s? Devices it created are deleted and updated fields restored.", j.Source)
+	imgui.Msgbox("Roll Back Import", question).Buttons(imgui.MsgboxButtonsYesNo).ResultCallback(func(result imgui.DialogResult) {
+		if result != imgui.DialogResultYes {
+			return
+		}
+		dataSvc.Go(func() {
+			if err := rollbackImport(j); err != nil {
+				reportError("rolling back import", err)
+			} else {
+				logWrite("Import of %s rolled back", j.Source)
+			}
+			dataSvc.Post(func() {
+				requestRefresh()
+				if showHistoryWindow {
+					showImportHistory()
+				}
+			})
+		})
+	})
+}
+
+func historyWindow() {
+	if !showHistoryWindow {
+		return
+	}
+
+	rows := make([]*imgui.TableRowWidget, 0, len(importHistory))
+	for _, j := range importHistory {
+		j := j
+		rows = append(rows, imgui.TableRow(
+			imgui.Label(j.Summary()).Wrapped(true),
+			imgui.Button("Roll Back##"+j.ID).Disabled(j.RolledBack != nil || writesDisabled()).OnClick(func() {
+				confirmRollback(j)
+			}),
+		))
+	}
+
+	imgui.Window("Import History").IsOpen(&showHistoryWindow).Size(700, 300).Layout(
+		imgui.Button("Reload").OnClick(showImportHistory),
+		imgui.Table().Columns(
+			imgui.TableColumn("Import"),
+			imgui.TableColumn("").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(90),
+		).Rows(rows...),
+	)
+}
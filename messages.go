@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"main/netbox-data-app/netbox"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// Number of messages kept for the log panel
+const maxLogEntries = 1000
+
+// logEntry is one message of the in-app log.
+type logEntry struct {
+	Time    time.Time
+	Error   bool
+	Message string
+	// Detail is the NetBox response body for NetBox errors.
+	Detail string
+}
+
+// messageLog collects what the app reports, for the log panel and the error
+// window. It is safe for concurrent use.
+type messageLog struct {
+	mu      sync.Mutex
+	entries []logEntry
+	// shown is the error on display in the error window, if any.
+	shown *logEntry
+}
+
+var messages = &messageLog{}
+
+func (l *messageLog) add(e logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > maxLogEntries {
+		l.entries = l.entries[len(l.entries)-maxLogEntries:]
+	}
+}
+
+// Entries returns a copy of the log, oldest first.
+func (l *messageLog) Entries() []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]logEntry(nil), l.entries...)
+}
+
+// Clear empties the log.
+func (l *messageLog) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+}
+
+// Shown returns the error on display, or nil.
+func (l *messageLog) Shown() *logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.shown
+}
+
+// Show puts e in the error window, replacing the one on display.
+func (l *messageLog) Show(e logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shown = &e
+}
+
+// Dismiss closes the error window.
+func (l *messageLog) Dismiss() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shown = nil
+}
+
+func errorEntry(action string, err error) logEntry {
+	e := logEntry{
+		Time:    time.Now(),
+		Error:   true,
+		Message: fmt.Sprintf("Error %s: %v", action, err),
+	}
+
+	var nbErr *netbox.Error
+	if errors.As(err, &nbErr) {
+		e.Detail = nbErr.Body
+	}
+	return e
+}
+
+// logError records a failure in the log panel and on stderr without
+// interrupting the user. action says what was being done, e.g. "fetching
+// sites".
+func logError(action string, err error) {
+	e := errorEntry(action, err)
+	fmt.Fprintln(os.Stderr, e.Message)
+	messages.add(e)
+}
+
+// logOutput is where logInfo echoes messages. The command line sends them
+// to stderr so stdout only carries what a command prints, e.g. the plan of
+// a dry run.
+var logOutput io.Writer = os.Stdout
+
+// logInfo records a message in the log panel and on logOutput.
+func logInfo(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintln(logOutput, msg)
+	messages.add(logEntry{Time: time.Now(), Message: msg})
+}
+
+// reportError records a failure and shows it in the error window. It is
+// meant for actions the user started from the GUI.
+func reportError(action string, err error) {
+	e := errorEntry(action, err)
+	fmt.Fprintln(os.Stderr, e.Message)
+	messages.add(e)
+	messages.Show(e)
+	imgui.Update()
+}
+
+var showLogWindow bool = false
+var errorDetail string
+
+// errorWindow shows the last reported error until it is dismissed.
+func errorWindow() {
+	shown := messages.Shown()
+	if shown == nil {
+		return
+	}
+
+	open := true
+	errorDetail = shown.Detail
+	imgui.Window("Error").IsOpen(&open).Flags(imgui.WindowFlagsNoCollapse|imgui.WindowFlagsAlwaysAutoResize).Layout(
+		imgui.Label(shown.Message).Wrapped(true),
+		imgui.Condition(shown.Detail != "",
+			imgui.Layout{
+				imgui.Label("NetBox response:"),
+				imgui.InputTextMultiline(&errorDetail).Flags(imgui.InputTextFlagsReadOnly).Size(600, 150),
+			},
+			nil,
+		),
+		imgui.Row(
+			imgui.Button("Dismiss").OnClick(messages.Dismiss),
+			imgui.Button("Show Log").OnClick(func() {
+				showLogWindow = true
+			}),
+		),
+	)
+	if !open {
+		messages.Dismiss()
+	}
+}
+
+// logRetry records a NetBox request that needed more than one attempt.
+func logRetry(s netbox.RequestStats) {
+	outcome := "succeeded"
+	if s.Err != nil {
+		outcome = "failed"
+	}
+	status := "no response"
+	if s.Status != 0 {
+		status = strconv.Itoa(s.Status)
+	}
+	logInfo("%s %s %s after %d attempts, %s waiting (last status %s)",
+		s.Method, s.URL, outcome, s.Attempts, s.Waited.Round(time.Millisecond), status)
+}
+
+// requestTotals summarises the requests made to NetBox for the log window.
+func requestTotals() string {
+	if nbClient == nil {
+		return ""
+	}
+	s := nbClient.Stats()
+	return fmt.Sprintf("NetBox requests: %d, retries: %d, failed: %d", s.Requests, s.Retries, s.Failures)
+}
+
+// logWindow shows every message the app has reported this session.
+func logWindow() {
+	if !showLogWindow {
+		return
+	}
+
+	entries := messages.Entries()
+	rows := make([]*imgui.TableRowWidget, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		level := "Info"
+		if e.Error {
+			level = "Error"
+		}
+		text := e.Message
+		if e.Detail != "" {
+			text += "\n" + strings.TrimSpace(e.Detail)
+		}
+		rows = append(rows, imgui.TableRow(
+			imgui.Label(e.Time.Format("15:04:05")),
+			imgui.Label(level),
+			imgui.Label(text).Wrapped(true),
+		))
+	}
+
+	imgui.Window("Log").IsOpen(&showLogWindow).Size(800, 300).Layout(
+		imgui.Row(
+			imgui.Button("Clear").OnClick(messages.Clear),
+			imgui.Label(requestTotals()),
+		),
+		imgui.Table().Columns(
+			imgui.TableColumn("Time").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(70),
+			imgui.TableColumn("Level").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(50),
+			imgui.TableColumn("Message"),
+		).Rows(rows...),
+	)
+}
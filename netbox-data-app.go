@@ -1,17 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
-	"encoding/json"
 	"fmt"
 	"image/color"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"main/netbox-data-app/netbox"
 
 	imgui "github.com/AllenDang/giu"
 	openapiclient "github.com/netbox-community/go-netbox/v4"
@@ -57,7 +60,7 @@ type Prefix struct {
 	MarkUtilized bool                   `json:"mark_utilized"`
 	Description  string                 `json:"description"`
 	Comments     string                 `json:"comments"`
-	Tags         []string               `json:"tags"`
+	Tags         []Tag                  `json:"tags"`
 	CustomFields map[string]interface{} `json:"custom_fields"`
 	Created      string                 `json:"created"`
 	LastUpdated  string                 `json:"last_updated"`
@@ -84,12 +87,13 @@ type VRF struct {
 }
 
 type VLAN struct {
-	ID          int    `json:"id"`
-	URL         string `json:"url"`
-	Display     string `json:"display"`
-	Name        string `json:"name"`
-	Vid         int    `json:"vid"`
-	Description string `json:"description"`
+	ID          int     `json:"id"`
+	URL         string  `json:"url"`
+	Display     string  `json:"display"`
+	Name        string  `json:"name"`
+	Vid         int     `json:"vid"`
+	Description string  `json:"description"`
+	Tenant      *Tenant `json:"tenant"`
 }
 
 type Role struct {
@@ -101,35 +105,28 @@ type Role struct {
 	Description string `json:"description"`
 }
 
-type ApiResponse struct {
-	Count    int      `json:"count"`
-	Next     *string  `json:"next"`
-	Previous *string  `json:"previous"`
-	Results  []Prefix `json:"results"`
-}
-
-type Device struct {
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
+type Tag struct {
+	ID      int    `json:"id"`
+	URL     string `json:"url"`
 	Display string `json:"display"`
-}
-
-type DeviceListResponse struct {
-	Count    int      `json:"count"`
-	Next     string   `json:"next"`
-	Previous string   `json:"previous"`
-	Results  []Device `json:"results"`
+	Name    string `json:"name"`
+	Slug    string `json:"slug"`
+	Color   string `json:"color"`
 }
 
 type DeviceDetails struct {
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
+	Display    string `json:"display"`
 	DeviceRole struct {
+		ID      int    `json:"id"`
 		Display string `json:"display"`
-	} `json:"device_role"`
+	} `json:"role"` // device_role before NetBox 3.6, renamed by the client
 	DeviceType struct {
+		ID           int    `json:"id"`
 		Display      string `json:"display"`
 		Manufacturer struct {
+			ID      int    `json:"id"`
 			Display string `json:"display"`
 		} `json:"manufacturer"`
 	} `json:"device_type"`
@@ -138,28 +135,82 @@ type DeviceDetails struct {
 	} `json:"status"`
 	Serial string `json:"serial"`
 	Tenant struct {
+		ID      int    `json:"id"`
 		Display string `json:"display"`
 	} `json:"tenant"`
 	Site struct {
+		ID      int    `json:"id"`
 		Display string `json:"display"`
 	} `json:"site"`
+	Location struct {
+		ID      int    `json:"id"`
+		Display string `json:"display"`
+	} `json:"location"`
+	Rack struct {
+		ID      int    `json:"id"`
+		Display string `json:"display"`
+	} `json:"rack"`
+	Position float64 `json:"position"`
+	Face     struct {
+		Value string `json:"value"`
+	} `json:"face"`
+	Platform struct {
+		ID      int    `json:"id"`
+		Display string `json:"display"`
+	} `json:"platform"`
+	AssetTag string `json:"asset_tag"`
+	Airflow  struct {
+		Value string `json:"value"`
+	} `json:"airflow"`
+	Tags         []Tag                  `json:"tags"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
 }
 
 type DeviceRequest struct {
-	Name         string `json:"name"`
-	DeviceType   int    `json:"device_type"`      // ID of the device type
-	DeviceRole   int    `json:"role"`             // ID of the device role
-	Site         int    `json:"site"`             // ID of the site
-	Tenant       int    `json:"tenant,omitempty"` // ID of the tenant (optional)
-	Manufacturer int    `json:"manufacturer"`     // ID of the manufacturer
-	Status       string `json:"status"`           // Status, e.g., "active"
-	Serial       string `json:"serial,omitempty"` // Serial number (optional)
+	Name         string                 `json:"name"`
+	DeviceType   int                    `json:"device_type"`             // ID of the device type
+	DeviceRole   int                    `json:"role"`                    // ID of the device role
+	Site         int                    `json:"site"`                    // ID of the site
+	Tenant       int                    `json:"tenant,omitempty"`        // ID of the tenant (optional)
+	Manufacturer int                    `json:"manufacturer"`            // ID of the manufacturer
+	Status       string                 `json:"status"`                  // Status, e.g., "active"
+	Serial       string                 `json:"serial,omitempty"`        // Serial number (optional)
+	Location     int                    `json:"location,omitempty"`      // ID of the location within the site (optional)
+	Rack         int                    `json:"rack,omitempty"`          // ID of the rack (optional)
+	Position     float64                `json:"position,omitempty"`      // Lowest U the device occupies, needs a rack and face
+	Face         string                 `json:"face,omitempty"`          // Rack face, "front" or "rear"
+	Platform     int                    `json:"platform,omitempty"`      // ID of the platform (optional)
+	AssetTag     string                 `json:"asset_tag,omitempty"`     // Asset tag, unique in NetBox (optional)
+	Airflow      string                 `json:"airflow,omitempty"`       // Airflow direction, e.g. "front-to-rear"
+	Tags         []int                  `json:"tags,omitempty"`          // IDs of the tags
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty"` // Custom field values by name
+}
+
+type VLANRequest struct {
+	Vid         int    `json:"vid"`              // Numeric VLAN ID (1-4094)
+	Name        string `json:"name"`             // VLAN Name
+	Description string `json:"description"`      // Optional Description
+	Tenant      int    `json:"tenant,omitempty"` // ID of the tenant (optional)
 }
 
 var apiClient *openapiclient.APIClient
+var nbClient *netbox.Client
+var appConfig *Config
+var currentSession *session
+var loginFailure string
+var activeProfile Profile
 var ctx context.Context
-var rows []*imgui.TableRowWidget
-var timer float32 = 10.0
+var dataSvc = newDataService()
+var objects = newObjectCache()
+var offline atomic.Bool
+var diskCacheEnabled bool = true
+var cacheDomain string
+var vlanTable *vlanSnapshot
+var deviceTable *deviceSnapshot
+var vlanRefreshSeconds int32 = int32(defaultRefreshInterval / time.Second)
+var deviceRefreshSeconds int32 = int32(defaultRefreshInterval / time.Second)
+var vlanRefreshPaused bool = false
+var deviceRefreshPaused bool = false
 var showEnterVLANWindow bool = false
 var showEnterDeviceWindow bool = false
 var showLoggedIn bool = true
@@ -171,655 +222,467 @@ var inputDeviceSerialNumber string = ""
 var inputDeviceName string = ""
 var inputIPAddressToSearchString string = ""
 var inputDeviceToSearchString string = ""
-var inputDomainLogIn string = "https://demo.netbox.dev"
+var inputDomainLogIn string = demoProfile.URL
 var inputAPITokenLogIn string = ""
-var listOfTenant []openapiclient.Tenant = make([]openapiclient.Tenant, 0)
-var listOfTenantName []string = make([]string, 0)
-var listOfDevice []int = make([]int, 0)
-var listOfDeviceName []string = make([]string, 0)
-var listOfDeviceType []int = make([]int, 0)
-var listOfDeviceTypeName []string = make([]string, 0)
-var listOfDeviceManufacturer []int = make([]int, 0)
-var listOfDeviceManufacturerName []string = make([]string, 0)
-var listOfDeviceSite []int = make([]int, 0)
-var listOfDeviceSiteName []string = make([]string, 0)
-var listOfDeviceRole []int = make([]int, 0)
-var listOfDeviceRoleName []string = make([]string, 0)
+var inputPassphraseLogIn string = ""
+var rememberToken bool = false
+var profileChoice int32 = 0
 var tenantChoice int32 = 0
-var deviceChoice int32 = 0
 var deviceTypeChoice int32 = 0
 var deviceManufacturerChoice int32 = 0
 var deviceSiteChoice int32 = 0
 var deviceRoleChoice int32 = 0
-
-func buildRows() []*imgui.TableRowWidget {
-
-	if timer <= 0.0 && !showDeviceScreen {
-
-		//Tenant
-		nulTenant := openapiclient.Tenant{
-			Name: "None",
-		}
-
-		listOfTenantName = append(listOfTenantName, "None")
-		listOfTenant = append(listOfTenant, nulTenant)
-
-		tenantList, _, err := apiClient.TenancyAPI.TenancyTenantsList(ctx).Execute()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching tenants: %v\n", err)
-		}
-
-		for _, tenant := range tenantList.Results {
-			listOfTenant = append(listOfTenant, tenant)
-			listOfTenantName = append(listOfTenantName, tenant.Name)
-		}
-
-		// Fetch all VLANs
-		availableVLANs, _, err := apiClient.IpamAPI.IpamVlansList(ctx).Limit(6000).Execute()
-
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Set headers for VLAN table
-		headers := []string{"ID", "Name", "Vid", "Prefix", "Tenant", "Description"}
-
-		var total = 0
-		for _, vlan := range availableVLANs.Results {
-			if strings.Contains(vlan.Name, inputIPAddressToSearchString) {
-				total++
-			}
-		}
-
-		// Initialize row lists
-		listOfDevice = listOfDevice[:0]
-		listOfDeviceName = listOfDeviceName[:0]
-
-		// Create rows for the VLANs
-		rows = make([]*imgui.TableRowWidget, total+1)
-
-		// Insert table headers
-		rows[0] = imgui.TableRow(
-			imgui.Label(headers[0]),
-			imgui.Label(headers[1]),
-			imgui.Label(headers[2]),
-			imgui.Label(headers[3]),
-			imgui.Label(headers[4]),
-			imgui.Label(headers[5]),
-		)
-		rows[0].BgColor(&(color.RGBA{200, 100, 100, 255}))
-
-		// Fill table with VLAN data
-		var i = 1
-		for _, vlan := range availableVLANs.Results {
-			if strings.Contains(vlan.Name, inputIPAddressToSearchString) {
-
-				// Extract data for each column
-				id := fmt.Sprintf("%d", vlan.Id)
-				name := vlan.Name
-				vid := fmt.Sprintf("%d", vlan.Vid) // Numeric VLAN ID (1-4094)
-				description := "None"
-				if vlan.Description != nil {
-					description = *vlan.Description
-				}
-
-				// Fetch tenant for VLAN
-				tenantName := fetchTenantForVLAN(vlan.Id)
-
-				// Fetch prefixes for VLAN
-				prefixes := fetchPrefixesForVLAN(vlan.Id)
-
-				// Insert row data
-				rows[i] = imgui.TableRow(
-					imgui.Label(id),
-					imgui.Label(name),
-					imgui.Label(vid),
-					imgui.Label(strings.Join(prefixes, ", ")), // Concatenate prefixes
-					imgui.Label(tenantName),
-					imgui.Label(description),
-				)
-				i++
-			}
-		}
-
-		timer = 50.0
+var deviceStatusChoice int32 = 1 // active
+var deviceLocationChoice int32 = 0
+var deviceRackChoice int32 = 0
+var devicePlatformChoice int32 = 0
+var deviceFaceChoice int32 = 0
+var deviceAirflowChoice int32 = 0
+var inputDevicePosition float32 = 0
+var inputDeviceAssetTag string = ""
+var deviceTagChecked = map[int]bool{}
+var inputDeviceCustomFields = map[string]*string{}
+
+// loadVLANTable fetches everything the VLAN screen shows. With a previous
+// snapshot only VLANs and prefixes changed since then are fetched.
+func loadVLANTable(prev *vlanSnapshot) (*vlanSnapshot, error) {
+	snap := &vlanSnapshot{Refreshed: time.Now()}
+
+	var since time.Time
+	var prevVLANs []VLAN
+	var prevPrefixes []Prefix
+	if prev != nil {
+		since = prev.Refreshed
+		prevVLANs = prev.VLANs
+		prevPrefixes = prev.Prefixes
+		snap.FullRefresh = prev.FullRefresh
 	}
 
-	return rows
-}
-
-// Function to fetch tenant for a VLAN
-func fetchTenantForVLAN(vlanID int32) string {
-	// Build the URL to fetch VLAN details
-	vlanURL := fmt.Sprintf(inputDomainLogIn+"/api/ipam/vlans/%d/", vlanID)
-
-	// Create the GET request
-	req, err := http.NewRequest("GET", vlanURL, nil)
+	//Tenant
+	tenantList, err := listCached(kindTenants, "/api/tenancy/tenants/", referenceDataTTL, func(t Tenant) int { return t.ID })
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return "Error"
+		logError("fetching tenants", err)
 	}
+	snap.Tenants = tenantList
 
-	// Add headers (e.g., API token)
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Fetch all VLANs
+	availableVLANs, fullVLANs, err := fetchChanged(kindVLANs, "/api/ipam/vlans/", prevVLANs, since, func(v VLAN) int { return v.ID })
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending request: %v\n", err)
-		return "Error"
+		return nil, err
 	}
-	defer resp.Body.Close()
+	snap.VLANs = availableVLANs
 
-	// Check for successful response
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "Error fetching VLAN: %s\n", string(body))
-		return "Error"
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	// Fetch all prefixes in one listing and group them by VLAN
+	prefixes, fullPrefixes, err := fetchChanged(kindPrefixes, "/api/ipam/prefixes/", prevPrefixes, since, func(p Prefix) int { return p.ID })
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return "Error"
+		logError("fetching prefixes", err)
+		prefixes = prevPrefixes
 	}
+	snap.Prefixes = prefixes
+	prefixesByVLAN := groupPrefixesByVLAN(prefixes)
 
-	// Parse the response JSON to get tenant info
-	var vlanData struct {
-		Tenant struct {
-			Name string `json:"name"`
-		} `json:"tenant"`
+	if fullVLANs && fullPrefixes {
+		snap.FullRefresh = snap.Refreshed
 	}
 
-	err = json.Unmarshal(body, &vlanData)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error unmarshaling VLAN data: %v\n", err)
-		return "Error"
-	}
+	for _, vlan := range availableVLANs {
+		description := "None"
+		if vlan.Description != "" {
+			description = vlan.Description
+		}
 
-	// Return tenant name or "None" if not assigned
-	if vlanData.Tenant.Name != "" {
-		return vlanData.Tenant.Name
+		// Tenant is nested in the VLAN list result
+		tenantName := "None"
+		if vlan.Tenant != nil && vlan.Tenant.Name != "" {
+			tenantName = vlan.Tenant.Name
+		}
+
+		snap.Rows = append(snap.Rows, vlanRow{
+			ID:          vlan.ID,
+			Name:        vlan.Name,
+			Vid:         vlan.Vid,
+			Prefixes:    prefixesByVLAN[vlan.ID],
+			Tenant:      tenantName,
+			Description: description,
+		})
 	}
-	return "None"
+
+	return snap, nil
 }
 
-// Helper function to fetch prefixes for a VLAN using REST API
-func fetchPrefixesForVLAN(vlanID int32) []string {
-	// Construct the URL for fetching prefixes with vlan_id filter
-	prefixesURL := fmt.Sprintf("%s/api/ipam/prefixes/?vlan_id=%d", inputDomainLogIn, vlanID)
+// applyVLANSnapshot makes a freshly loaded VLAN snapshot visible to the UI
+func applyVLANSnapshot(snap *vlanSnapshot) {
+	vlanTable = snap
 
-	// Create a new request
-	req, err := http.NewRequest("GET", prefixesURL, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return []string{}
-	}
+	old, reg := publishVLANReferences(snap)
+	remapChoice(&tenantChoice, old.Tenants, reg.Tenants)
+	refreshImportPreview()
+}
 
-	// Add authorization header with your API token
-	req.Header.Add("Authorization", "Token "+inputAPITokenLogIn) // Replace with your token
+// publishVLANReferences updates the registry with the reference objects of a
+// VLAN snapshot and returns the registry before and after the change.
+func publishVLANReferences(snap *vlanSnapshot) (*registry, *registry) {
+	old := currentRegistry()
+	reg := updateRegistry(func(r *registry) {
+		r.Tenants = newRefList(tenantItems(snap.Tenants))
+	})
+	return old, reg
+}
 
-	// Create an HTTP client and make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error making request: %v\n", err)
-		return []string{}
-	}
-	defer resp.Body.Close()
+func buildRows() []*imgui.TableRowWidget {
+	// Set headers for VLAN table
+	headers := []string{"ID", "Name", "Vid", "Prefix", "Tenant", "Description"}
+
+	// Insert table headers
+	header := imgui.TableRow(
+		imgui.Label(headers[0]),
+		imgui.Label(headers[1]),
+		imgui.Label(headers[2]),
+		imgui.Label(headers[3]),
+		imgui.Label(headers[4]),
+		imgui.Label(headers[5]),
+	)
+	header.BgColor(&(color.RGBA{200, 100, 100, 255}))
 
-	// Check for non-200 status code
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "Error response from NetBox: %s\n", string(bodyBytes))
-		return []string{}
+	rows := []*imgui.TableRowWidget{header}
+	if vlanTable == nil {
+		return rows
 	}
 
-	// Parse the response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return []string{}
+	// Fill table with VLAN data
+	for _, vlan := range vlanTable.Rows {
+		if strings.Contains(vlan.Name, inputIPAddressToSearchString) {
+			rows = append(rows, imgui.TableRow(
+				imgui.Label(fmt.Sprintf("%d", vlan.ID)),
+				imgui.Label(vlan.Name),
+				imgui.Label(fmt.Sprintf("%d", vlan.Vid)),       // Numeric VLAN ID (1-4094)
+				imgui.Label(strings.Join(vlan.Prefixes, ", ")), // Concatenate prefixes
+				imgui.Label(vlan.Tenant),
+				imgui.Label(vlan.Description),
+			))
+		}
 	}
 
-	// Unmarshal the JSON response
-	var prefixResponse struct {
-		Results []struct {
-			Prefix string `json:"prefix"`
-		} `json:"results"`
-	}
-	err = json.Unmarshal(bodyBytes, &prefixResponse)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error unmarshalling response: %v\n", err)
-		return []string{}
-	}
+	return rows
+}
 
-	// Collect prefixes from the response
-	var prefixList []string
-	for _, prefix := range prefixResponse.Results {
-		prefixList = append(prefixList, prefix.Prefix)
+// Helper function to group prefixes by the VLAN they are assigned to
+func groupPrefixesByVLAN(prefixes []Prefix) map[int][]string {
+	prefixesByVLAN := make(map[int][]string)
+	for _, prefix := range prefixes {
+		if prefix.VLAN == nil {
+			continue
+		}
+		prefixesByVLAN[prefix.VLAN.ID] = append(prefixesByVLAN[prefix.VLAN.ID], prefix.Prefix)
 	}
 
-	return prefixList
+	return prefixesByVLAN
 }
 
-func getManufacturer() {
-	apiUrl := inputDomainLogIn + "/api/dcim/manufacturers/"
-
-	// Create an HTTP GET request
-	req, err := http.NewRequestWithContext(context.Background(), "GET", apiUrl, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
-	}
+// namedObject is the subset of fields the lookup lists need.
+type namedObject struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Model string `json:"model"`
+	// Site is set for objects that belong to one, like racks and locations.
+	Site *struct {
+		ID int `json:"id"`
+	} `json:"site,omitempty"`
+}
 
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
+func namedObjectID(o namedObject) int { return o.ID }
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func getManufacturer() []namedObject {
+	result, err := listCached(kindManufacturers, "/api/dcim/manufacturers/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching manufacturers: %v\n", err)
-		return
+		logError("fetching manufacturers", err)
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	// Read and print the response
-	body, err := io.ReadAll(resp.Body)
+func getDeviceType() []namedObject {
+	result, err := listCached(kindDeviceTypes, "/api/dcim/device-types/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return
+		logError("fetching device types", err)
 	}
 
-	// Print the raw JSON response (optional)
-	fmt.Printf("Response: %s\n", string(body))
-
-	// Parse JSON response to extract manufacturer information
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-		return
-	}
-
-	listOfDeviceManufacturer = listOfDeviceManufacturer[:0]
-	listOfDeviceManufacturerName = listOfDeviceManufacturerName[:0]
-
-	listOfDeviceManufacturer = append(listOfDeviceManufacturer, 0)
-	listOfDeviceManufacturerName = append(listOfDeviceManufacturerName, "None")
-
-	if results, ok := result["results"].([]interface{}); ok {
-		for _, r := range results {
-			if manufacturer, ok := r.(map[string]interface{}); ok {
-				if idFloat, ok := manufacturer["id"].(float64); ok {
-					id := int(idFloat) // Convert float64 to int32
-					listOfDeviceManufacturer = append(listOfDeviceManufacturer, id)
-					listOfDeviceManufacturerName = append(listOfDeviceManufacturerName, manufacturer["name"].(string))
-				}
-			}
-		}
+	// Device types are named by their model
+	for i := range result {
+		result[i].Name = result[i].Model
 	}
+	return result
 }
 
-func getDeviceType() {
-	apiUrl := inputDomainLogIn + "/api/dcim/device-types/"
-
-	// Create an HTTP GET request
-	req, err := http.NewRequestWithContext(context.Background(), "GET", apiUrl, nil)
+func getDeviceRole() []namedObject {
+	result, err := listCached(kindDeviceRoles, "/api/dcim/device-roles/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
+		logError("fetching device roles", err)
 	}
+	return result
+}
 
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func getDeviceSite() []namedObject {
+	result, err := listCached(kindSites, "/api/dcim/sites/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching device types: %v\n", err)
-		return
+		logError("fetching sites", err)
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
+func getLocation() []namedObject {
+	result, err := listCached(kindLocations, "/api/dcim/locations/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return
-	}
-
-	// Print the raw JSON response (optional)
-	fmt.Printf("Response: %s\n", string(body))
-
-	// Parse JSON response to extract device type information
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-		return
-	}
-
-	listOfDeviceType = listOfDeviceType[:0]
-	listOfDeviceTypeName = listOfDeviceTypeName[:0]
-
-	listOfDeviceType = append(listOfDeviceType, 0)
-	listOfDeviceTypeName = append(listOfDeviceTypeName, "None")
-
-	// Extract and print device type IDs, models, and manufacturer names
-	if results, ok := result["results"].([]interface{}); ok {
-		for _, r := range results {
-			if deviceType, ok := r.(map[string]interface{}); ok {
-
-				if idFloat, ok := deviceType["id"].(float64); ok {
-					id := int(idFloat) // Convert float64 to int32
-					listOfDeviceType = append(listOfDeviceType, id)
-					listOfDeviceTypeName = append(listOfDeviceTypeName, deviceType["model"].(string))
-				}
-			}
-		}
+		logError("fetching locations", err)
 	}
+	return result
 }
 
-func getDeviceRole() {
-	apiUrl := inputDomainLogIn + "/api/dcim/device-roles/"
-
-	// Create an HTTP GET request
-	req, err := http.NewRequestWithContext(context.Background(), "GET", apiUrl, nil)
+func getRack() []namedObject {
+	result, err := listCached(kindRacks, "/api/dcim/racks/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
+		logError("fetching racks", err)
 	}
+	return result
+}
 
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func getPlatform() []namedObject {
+	result, err := listCached(kindPlatforms, "/api/dcim/platforms/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching device roles: %v\n", err)
-		return
+		logError("fetching platforms", err)
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
+func getTag() []namedObject {
+	result, err := listCached(kindTags, "/api/extras/tags/", referenceDataTTL, namedObjectID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return
-	}
-
-	// Print the raw JSON response (optional)
-	fmt.Printf("Response: %s\n", string(body))
-
-	// Parse JSON response to extract device role information
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-		return
-	}
-
-	listOfDeviceRole = listOfDeviceRole[:0]
-	listOfDeviceRoleName = listOfDeviceRoleName[:0]
-
-	listOfDeviceRole = append(listOfDeviceRole, 0)
-	listOfDeviceRoleName = append(listOfDeviceRoleName, "None")
-
-	// Extract and print device role IDs and names
-	if results, ok := result["results"].([]interface{}); ok {
-		for _, r := range results {
-			if role, ok := r.(map[string]interface{}); ok {
-
-				if idFloat, ok := role["id"].(float64); ok {
-					id := int(idFloat) // Convert float64 to int32
-					listOfDeviceRole = append(listOfDeviceRole, id)
-					listOfDeviceRoleName = append(listOfDeviceRoleName, role["name"].(string))
-				}
-			}
-		}
+		logError("fetching tags", err)
 	}
+	return result
 }
 
-func getDeviceSite() {
-	apiUrl := inputDomainLogIn + "/api/dcim/sites/"
-
-	// Create an HTTP GET request
-	req, err := http.NewRequestWithContext(context.Background(), "GET", apiUrl, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
+// loadDeviceTable fetches everything the device screen shows. With a
+// previous snapshot only devices changed since then are fetched.
+func loadDeviceTable(prev *deviceSnapshot) (*deviceSnapshot, error) {
+	snap := &deviceSnapshot{Refreshed: time.Now()}
+
+	var since time.Time
+	var prevDevices []DeviceDetails
+	if prev != nil {
+		since = prev.Refreshed
+		prevDevices = prev.Devices
+		snap.FullRefresh = prev.FullRefresh
 	}
 
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	snap.Manufacturers = getManufacturer()
+	snap.Sites = getDeviceSite()
+	snap.DeviceTypes = getDeviceType()
+	snap.DeviceRoles = getDeviceRole()
+	snap.Locations = getLocation()
+	snap.Racks = getRack()
+	snap.Platforms = getPlatform()
+	snap.Tags = getTag()
+	snap.CustomFields = getDeviceCustomFields()
+
+	// The list endpoint already carries serial, tenant, site and device type
+	deviceList, full, err := fetchChanged(kindDevices, "/api/dcim/devices/", prevDevices, since, func(d DeviceDetails) int { return d.ID })
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching sites: %v\n", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		return
+	fillMissingDeviceDetails(deviceList)
+	snap.Devices = deviceList
+	if full {
+		snap.FullRefresh = snap.Refreshed
 	}
 
-	// Print the raw JSON response (optional)
-	fmt.Printf("Response: %s\n", string(body))
+	return snap, nil
+}
 
-	// Parse JSON response to extract site information
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
+// applyDeviceSnapshot makes a freshly loaded device snapshot visible to the
+// UI. Snapshots the user asked for are also exported; scheduled refreshes
+// are not, so a spreadsheet open in Excel is not rewritten every minute.
+func applyDeviceSnapshot(snap *deviceSnapshot, export bool) {
+	deviceTable = snap
+
+	old, reg := publishDeviceReferences(snap)
+	remapChoice(&deviceManufacturerChoice, old.Manufacturers, reg.Manufacturers)
+	remapChoice(&deviceSiteChoice, old.Sites, reg.Sites)
+	remapChoice(&deviceTypeChoice, old.DeviceTypes, reg.DeviceTypes)
+	remapChoice(&deviceRoleChoice, old.DeviceRoles, reg.DeviceRoles)
+	remapChoice(&devicePlatformChoice, old.Platforms, reg.Platforms)
+	site := reg.Sites.At(deviceSiteChoice).ID
+	remapChoice(&deviceLocationChoice, old.Locations.Scope(site), reg.Locations.Scope(site))
+	remapChoice(&deviceRackChoice, old.Racks.Scope(site), reg.Racks.Scope(site))
+	refreshImportPreview()
+	if !export {
 		return
 	}
 
-	listOfDeviceSite = listOfDeviceSite[:0]
-	listOfDeviceSiteName = listOfDeviceSiteName[:0]
-
-	listOfDeviceSite = append(listOfDeviceSite, 0)
-	listOfDeviceSiteName = append(listOfDeviceSiteName, "None")
-
-	// Extract and print site IDs and names
-	if results, ok := result["results"].([]interface{}); ok {
-		for _, r := range results {
-			if site, ok := r.(map[string]interface{}); ok {
-				if idFloat, ok := site["id"].(float64); ok {
-					id := int(idFloat) // Convert float64 to int32
-					listOfDeviceSite = append(listOfDeviceSite, id)
-					listOfDeviceSiteName = append(listOfDeviceSiteName, site["name"].(string))
-				}
-			}
+	// Write the export off the render loop
+	filter := inputDeviceToSearchString
+	dataSvc.Go(func() {
+		path := exportPath("devices_data.xlsx")
+		if err := exportDevices(snap.Devices, filter, path); err != nil {
+			reportError("saving "+path, err)
+			return
 		}
-	}
+		logInfo("Excel file created successfully: %s", path)
+	})
 }
 
-func buildDeviceRows() []*imgui.TableRowWidget {
-
-	if timer <= 0.0 {
-
-		getManufacturer()
-		getDeviceSite()
-		getDeviceType()
-		getDeviceRole()
-
-		// Set headers
-		headers := []string{"Name", "Serial Number", "Tenant", "Site", "Manufacturer"}
+// publishDeviceReferences updates the registry with the reference objects of
+// a device snapshot and returns the registry before and after the change.
+func publishDeviceReferences(snap *deviceSnapshot) (*registry, *registry) {
+	old := currentRegistry()
+	reg := updateRegistry(func(r *registry) {
+		r.Manufacturers = newRefList(namedItems(snap.Manufacturers))
+		r.Sites = newRefList(namedItems(snap.Sites))
+		r.DeviceTypes = newRefList(namedItems(snap.DeviceTypes))
+		r.DeviceRoles = newRefList(namedItems(snap.DeviceRoles))
+		r.Locations = newRefList(namedItems(snap.Locations))
+		r.Racks = newRefList(namedItems(snap.Racks))
+		r.Platforms = newRefList(namedItems(snap.Platforms))
+		r.Tags = newRefList(namedItems(snap.Tags))
+		r.CustomFields = snap.CustomFields
+	})
+	return old, reg
+}
 
-		//Interface
-		listOfDevice = listOfDevice[:0]
-		listOfDeviceName = listOfDeviceName[:0]
+func buildDeviceRows() []*imgui.TableRowWidget {
+	// Set headers
+	headers := []string{"Name", "Serial Number", "Tenant", "Site", "Manufacturer"}
+
+	header := imgui.TableRow(
+		imgui.Label(headers[0]),
+		imgui.Label(headers[1]),
+		imgui.Label(headers[2]),
+		imgui.Label(headers[3]),
+		imgui.Label(headers[4]),
+	)
+	header.BgColor(&(color.RGBA{200, 100, 100, 255}))
 
-		//Interface
-		listOfDeviceName = append(listOfDeviceName, "None")
-		listOfDevice = append(listOfDevice, 0)
-		netboxURL := inputDomainLogIn + "/api/dcim/devices/?per_page=1000"
+	rows := []*imgui.TableRowWidget{header}
+	if deviceTable == nil {
+		return rows
+	}
 
-		// Create a new request
-		req, err := http.NewRequest("GET", netboxURL, nil)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
+	// Fill data
+	for _, device := range deviceTable.Devices {
+		if strings.Contains(device.Display, inputDeviceToSearchString) {
+			rows = append(rows, imgui.TableRow(
+				imgui.Label(device.Display),
+				imgui.Label(device.Serial),
+				imgui.Label(device.Tenant.Display),
+				imgui.Label(device.Site.Display),
+				imgui.Label(device.DeviceType.Manufacturer.Display),
+			))
 		}
+	}
 
-		// Add authentication header
-		req.Header.Add("Authorization", "Token "+inputAPITokenLogIn)
+	return rows
+}
 
-		// Create an HTTP client and set a timeout
-		client := &http.Client{}
+// exportDevices writes the devices matching filter to the spreadsheet at path
+func exportDevices(devices []DeviceDetails, filter, path string) error {
+	// Create a new Excel file
+	f := excel.NewFile()
+	sheetName := "Sheet1"
+	index, _ := f.NewSheet(sheetName)
 
-		// Make the request
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error making request: %v\n", err)
-		}
-		defer resp.Body.Close()
+	// Create header row
+	sheetHeaders := []string{
+		"Name", "Type", "Site", "Tenant", "Label",
+	}
 
-		// Check the response status
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			fmt.Fprintf(os.Stderr, "Error response from NetBox: %v\n", string(bodyBytes))
-		}
+	for i, header := range sheetHeaders {
+		cell, _ := excel.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, header)
+	}
 
-		// Parse the response body
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-		}
+	// Use the registry names so the sheet can be imported again
+	reg := currentRegistry()
 
-		// Unmarshal the JSON response
-		var deviceList DeviceListResponse
-		err = json.Unmarshal(bodyBytes, &deviceList)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error unmarshalling response: %v\n", err)
-		}
+	// Fill data
+	var i = 1
+	for _, device := range devices {
+		if strings.Contains(device.Display, filter) {
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", i+1), device.Display)
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", i+1), refName(reg.DeviceTypes, device.DeviceType.ID, device.DeviceType.Display))
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", i+1), refName(reg.Sites, device.Site.ID, device.Site.Display))
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", i+1), refName(reg.Tenants, device.Tenant.ID, device.Tenant.Display))
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", i+1), "Office")
 
-		// Print the list of devices and their IDs
-		for _, device := range deviceList.Results {
-			listOfDevice = append(listOfDevice, device.Id)
-			listOfDeviceName = append(listOfDeviceName, device.Display)
+			i++
 		}
+	}
 
-		total := 0
-
-		for i := 1; i < len(listOfDeviceName); i++ {
-			if strings.Contains(listOfDeviceName[i], inputDeviceToSearchString) {
-				total++
-			}
-		}
-
-		rows = make([]*imgui.TableRowWidget, total+1)
-
-		rows[0] = imgui.TableRow(
-			imgui.Label(headers[0]),
-			imgui.Label(headers[1]),
-			imgui.Label(headers[2]),
-			imgui.Label(headers[3]),
-			imgui.Label(headers[4]),
-		)
-
-		rows[0].BgColor(&(color.RGBA{200, 100, 100, 255}))
-
-		// Create a new Excel file
-		f := excel.NewFile()
-		sheetName := "Sheet1"
-		index, _ := f.NewSheet(sheetName)
+	// Set the active sheet
+	f.SetActiveSheet(index)
 
-		// Create header row
-		sheetHeaders := []string{
-			"Name", "Type", "Site", "Tenant", "Label",
-		}
+	// Save the file
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return f.SaveAs(path)
+}
 
-		for i, header := range sheetHeaders {
-			cell, _ := excel.CoordinatesToCellName(i+1, 1)
-			f.SetCellValue(sheetName, cell, header)
+// Number of concurrent requests used when devices need a detail fetch
+const deviceDetailWorkers = 8
+
+// Older NetBox releases nest device types without their manufacturer in the
+// device list. Only those devices are fetched individually, through a bounded
+// pool of workers.
+func fillMissingDeviceDetails(devices []DeviceDetails) {
+	var missing []int
+	for i, device := range devices {
+		if device.DeviceType.Display != "" && device.DeviceType.Manufacturer.Display == "" {
+			missing = append(missing, i)
 		}
+	}
+	if len(missing) == 0 {
+		return
+	}
 
-		// Fill data
-		var i = 1
-		for j := 1; j < len(listOfDeviceName); j++ {
-			if strings.Contains(listOfDeviceName[j], inputDeviceToSearchString) {
-				apiUrl := fmt.Sprintf(inputDomainLogIn+"/api/dcim/devices/%d/", listOfDevice[j])
-
-				// Make the HTTP request
-				req, err := http.NewRequestWithContext(context.Background(), "GET", apiUrl, nil)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-					continue
-				}
-
-				// Add authentication token (replace with your actual token)
-				req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-
-				// Perform the request
-				client := &http.Client{}
-				resp, err := client.Do(req)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error fetching device details: %v\n", err)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < deviceDetailWorkers && w < len(missing); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var details DeviceDetails
+				if err := nbClient.Get(ctx, "/api/dcim/devices/", devices[i].ID, &details); err != nil {
+					logError("fetching device details", err)
 					continue
 				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK {
-					fmt.Fprintf(os.Stderr, "Error: HTTP %v\n", resp.Status)
-				}
-
-				// Read the response body
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
-				}
-
-				// Parse the JSON response
-				var deviceDetails DeviceDetails
-				err = json.Unmarshal(body, &deviceDetails)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-				}
-
-				rows[i] = imgui.TableRow(
-					imgui.Label(listOfDeviceName[j]),
-					imgui.Label(deviceDetails.Serial),
-					imgui.Label(deviceDetails.Tenant.Display),
-					imgui.Label(deviceDetails.Site.Display),
-					imgui.Label(deviceDetails.DeviceType.Manufacturer.Display),
-				)
-
-				f.SetCellValue(sheetName, fmt.Sprintf("A%d", i+1), listOfDeviceName[j])
-				f.SetCellValue(sheetName, fmt.Sprintf("B%d", i+1), deviceDetails.DeviceType.Display)
-				f.SetCellValue(sheetName, fmt.Sprintf("C%d", i+1), deviceDetails.Site)
-				f.SetCellValue(sheetName, fmt.Sprintf("D%d", i+1), deviceDetails.Tenant)
-				f.SetCellValue(sheetName, fmt.Sprintf("E%d", i+1), "Office")
-
-				i++
+				devices[i] = details
 			}
-		}
-
-		// Set the active sheet
-		f.SetActiveSheet(index)
-
-		// Save the file
-		if err := f.SaveAs("devices_data.xlsx"); err != nil {
-			log.Fatalf("Error saving file: %v\n", err)
-		}
-
-		fmt.Println("Excel file created successfully: devices_data.xlsx")
-
-		timer = 50.0
+		}()
 	}
 
-	return rows
+	for _, i := range missing {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 }
 
 func predictDevice() {
+	summary, err := predictDeviceLocation("devices_data.csv")
+	if err != nil {
+		reportError("predicting device location", err)
+		return
+	}
+	logInfo("%s", summary)
+}
 
-	file, err := os.Open("devices_data.csv")
+// predictDeviceLocation trains a decision tree on the exported device list
+// at path and returns the evaluation summary of its predictions.
+func predictDeviceLocation(path string) (string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Failed to open CSV file: %v", err)
+		return "", fmt.Errorf("failed to open CSV file: %w", err)
 	}
 	defer file.Close()
 
@@ -829,19 +692,19 @@ func predictDevice() {
 	// Read all the records
 	records, err := reader.ReadAll()
 	if err != nil {
-		log.Fatalf("Failed to read CSV file: %v", err)
+		return "", fmt.Errorf("failed to read CSV file: %w", err)
 	}
 
 	// Ensure there are at least some records, including the header
 	if len(records) == 0 {
-		log.Fatal("No records found in the CSV file.")
+		return "", fmt.Errorf("no records found in the CSV file")
 	}
 	header := records[0]
 	expectedNumFields := len(header)
 
 	// Ensure there's a header row
 	if expectedNumFields == 0 {
-		log.Fatal("No header fields found.")
+		return "", fmt.Errorf("no header fields found")
 	}
 
 	// Prepare to create a dataset
@@ -857,17 +720,17 @@ func predictDevice() {
 
 	// Check if we have any valid records to parse
 	if len(filteredRecords) == 0 {
-		log.Fatal("No valid records found after filtering.")
+		return "", fmt.Errorf("no valid records found after filtering")
 	}
 
 	// Create a DenseInstances object by directly parsing the CSV
-	rawData, err := base.ParseCSVToInstances("devices_data.csv", true)
+	rawData, err := base.ParseCSVToInstances(path, true)
 	if err != nil {
-		log.Fatalf("Failed to parse CSV to instances: %v", err)
+		return "", fmt.Errorf("failed to parse CSV to instances: %w", err)
 	}
 
-	// Print a summary of the data
-	fmt.Println(rawData)
+	attributes, rows := rawData.Size()
+	logInfo("Training on %d devices with %d attributes", rows, attributes)
 
 	// Create a new ID3 Decision Tree with a 0.6 pruning factor
 	decisionTree := trees.NewID3DecisionTree(0.6)
@@ -877,29 +740,29 @@ func predictDevice() {
 
 	// Check if the data split succeeded
 	if trainData == nil || testData == nil {
-		log.Fatal("Failed to split data into training and testing sets.")
+		return "", fmt.Errorf("failed to split data into training and testing sets")
 	}
 
 	// Train the decision tree model using the training data
 	err = decisionTree.Fit(trainData)
 	if err != nil {
-		log.Fatal("Failed to train the model:", err)
+		return "", fmt.Errorf("failed to train the model: %w", err)
 	}
 
 	// Make predictions on the test set
 	predictions, err := decisionTree.Predict(testData)
 	if err != nil {
-		log.Fatal("Failed to predict on test data:", err)
+		return "", fmt.Errorf("failed to predict on test data: %w", err)
 	}
 
 	// Get the confusion matrix to evaluate the model
 	confusionMat, err := evaluation.GetConfusionMatrix(testData, predictions)
 	if err != nil {
-		log.Fatalf("Unable to get confusion matrix: %s", err)
+		return "", fmt.Errorf("unable to get confusion matrix: %w", err)
 	}
 
-	// Print the evaluation summary (precision, recall, F1 score, etc.)
-	fmt.Println(evaluation.GetSummary(confusionMat))
+	// The evaluation summary (precision, recall, F1 score, etc.)
+	return evaluation.GetSummary(confusionMat), nil
 }
 
 func addVLANConfirmation() {
@@ -907,15 +770,11 @@ func addVLANConfirmation() {
 		switch result {
 		case imgui.DialogResultYes:
 			// Prepare the request body as a JSON payload
-			vlanData := map[string]interface{}{
-				"vid":         inputVLANVid,  // Numeric VLAN ID (1-4094)
-				"name":        inputVLANName, // VLAN Name
-				"description": inputVLANDesc, // Optional Description
-			}
-
-			// Add tenant if selected
-			if tenantChoice != 0 {
-				vlanData["tenant"] = listOfTenant[tenantChoice].Id
+			vlanData := VLANRequest{
+				Vid:         int(inputVLANVid),
+				Name:        inputVLANName,
+				Description: inputVLANDesc,
+				Tenant:      currentRegistry().Tenants.At(tenantChoice).ID, // Left out when none is selected
 			}
 
 			// Add site if selected
@@ -923,43 +782,15 @@ func addVLANConfirmation() {
 				vlanData["site"] = listOfSite[siteChoice].Id
 			}*/
 
-			// Convert the data to JSON
-			jsonData, err := json.Marshal(vlanData)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error marshaling VLAN data: %v\n", err)
-				return
-			}
-
-			// Create the HTTP request
-			req, err := http.NewRequest("POST", inputDomainLogIn+"/api/ipam/vlans/", bytes.NewBuffer(jsonData))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-				return
-			}
-
-			// Add headers
-			req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-			req.Header.Set("Content-Type", "application/json")
-
-			// Send the request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error sending request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			// Handle the response
-			if resp.StatusCode != http.StatusCreated {
-				body, _ := io.ReadAll(resp.Body)
-				fmt.Fprintf(os.Stderr, "Error creating VLAN: %s\n", string(body))
-				return
-			}
-
-			fmt.Println("VLAN successfully created")
+			dataSvc.Go(func() {
+				if err := createVLAN(vlanData); err != nil {
+					reportError("creating VLAN", err)
+					return
+				}
 
-			resetRefreshTimer() // Reset any timers if applicable
+				logWrite("VLAN successfully created")
+				dataSvc.Post(requestRefresh)
+			})
 
 		case imgui.DialogResultNo:
 			fmt.Println("No clicked")
@@ -974,57 +805,56 @@ func addDeviceConfirmation() {
 		switch result {
 		case imgui.DialogResultYes:
 
+			reg := currentRegistry()
+			site := reg.Sites.At(deviceSiteChoice).ID
 			deviceData := DeviceRequest{
 				Name:         inputDeviceName,
-				DeviceType:   listOfDeviceType[deviceTypeChoice],
-				DeviceRole:   listOfDeviceRole[deviceRoleChoice],
-				Site:         listOfDeviceSite[deviceSiteChoice],
-				Tenant:       int(listOfTenant[tenantChoice].Id),
-				Manufacturer: listOfDeviceManufacturer[deviceManufacturerChoice],
-				Status:       "active",                // Device status
+				DeviceType:   reg.DeviceTypes.At(deviceTypeChoice).ID,
+				DeviceRole:   reg.DeviceRoles.At(deviceRoleChoice).ID,
+				Site:         site,
+				Tenant:       reg.Tenants.At(tenantChoice).ID,
+				Manufacturer: reg.Manufacturers.At(deviceManufacturerChoice).ID,
+				Status:       deviceStatuses[deviceStatusChoice],
 				Serial:       inputDeviceSerialNumber, // Serial number
+				Location:     reg.Locations.Scope(site).At(deviceLocationChoice).ID,
+				Rack:         reg.Racks.Scope(site).At(deviceRackChoice).ID,
+				Position:     float64(inputDevicePosition),
+				Face:         deviceFaces[deviceFaceChoice],
+				Platform:     reg.Platforms.At(devicePlatformChoice).ID,
+				AssetTag:     strings.TrimSpace(inputDeviceAssetTag),
+				Airflow:      deviceAirflows[deviceAirflowChoice],
 			}
-
-			// Convert the device data to JSON
-			jsonData, err := json.Marshal(deviceData)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error marshalling device data: %v\n", err)
-				return
-			}
-
-			// NetBox API URL to create a new device
-			apiUrl := inputDomainLogIn + "/api/dcim/devices/"
-
-			// Create an HTTP POST request with the device data
-			req, err := http.NewRequestWithContext(context.Background(), "POST", apiUrl, bytes.NewBuffer(jsonData))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-				return
-			}
-
-			req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-			req.Header.Set("Content-Type", "application/json")
-
-			// Send the request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating device: %v\n", err)
-				return
+			for i := 1; i < reg.Tags.Len(); i++ {
+				if tag := reg.Tags.At(int32(i)); deviceTagChecked[tag.ID] {
+					deviceData.Tags = append(deviceData.Tags, tag.ID)
+				}
 			}
-			defer resp.Body.Close()
-
-			// Check if the device was created successfully
-			if resp.StatusCode != http.StatusCreated {
-				// Print detailed error message from the response
-				body, _ := io.ReadAll(resp.Body)
-				fmt.Fprintf(os.Stderr, "Error: HTTP %v\nResponse: %s\n", resp.Status, string(body))
-				return
+			for _, field := range reg.CustomFields {
+				input, ok := inputDeviceCustomFields[field.Name]
+				if !ok || strings.TrimSpace(*input) == "" {
+					continue
+				}
+				value, err := field.Parse(*input)
+				if err != nil {
+					reportError("creating device", err)
+					return
+				}
+				if deviceData.CustomFields == nil {
+					deviceData.CustomFields = map[string]interface{}{}
+				}
+				deviceData.CustomFields[field.Name] = value
 			}
 
-			fmt.Println("Device created successfully!")
+			// Create the device in NetBox
+			dataSvc.Go(func() {
+				if err := createDevice(deviceData); err != nil {
+					reportError("creating device", err)
+					return
+				}
 
-			resetRefreshTimer()
+				logWrite("Device created successfully!")
+				dataSvc.Post(requestRefresh)
+			})
 		case imgui.DialogResultNo:
 			fmt.Println("No clicked")
 		}
@@ -1033,190 +863,252 @@ func addDeviceConfirmation() {
 	})
 }
 
-func importDeviceFromCSV() {
-	f, err := excel.OpenFile("DeviceToImport.xlsx")
-
-	if err != nil {
-		fmt.Println(err)
-		return
+// deviceDetailWidgets are the Add Device inputs beyond the required
+// fields: placement, platform, asset tag, airflow, tags and custom fields.
+func deviceDetailWidgets(reg *registry) imgui.Widget {
+	site := reg.Sites.At(deviceSiteChoice).ID
+	locations, racks := reg.Locations.Scope(site), reg.Racks.Scope(site)
+	faces, airflows := choiceNames(deviceFaces), choiceNames(deviceAirflows)
+
+	tags := make([]imgui.Widget, 0, reg.Tags.Len())
+	for i := 1; i < reg.Tags.Len(); i++ {
+		tag := reg.Tags.At(int32(i))
+		checked := deviceTagChecked[tag.ID]
+		tags = append(tags, imgui.Checkbox(fmt.Sprintf("%s##tag%d", tag.Name, tag.ID), &checked).OnChange(func() {
+			deviceTagChecked[tag.ID] = checked
+		}))
 	}
-	defer func() {
-		// Close the spreadsheet.
-		if err := f.Close(); err != nil {
-			fmt.Println(err)
-		}
-	}()
 
-	rows, err := f.GetRows("Sheet1")
-	if err != nil {
-		fmt.Println(err)
-		return
+	fields := make([]imgui.Widget, 0, len(reg.CustomFields))
+	for _, field := range reg.CustomFields {
+		input, ok := inputDeviceCustomFields[field.Name]
+		if !ok {
+			input = new(string)
+			inputDeviceCustomFields[field.Name] = input
+		}
+		fields = append(fields, imgui.InputText(input).Label(fmt.Sprintf("%s (%s)##cf_%s", field.Title(), field.Type.Value, field.Name)).Size(300))
 	}
 
-	for _, row := range rows {
-
-		deviceTypeIndex := 0
+	return imgui.Layout{
+		imgui.Combo("Location", locations.At(deviceLocationChoice).Name, locations.Names(), &deviceLocationChoice).Size(300),
+		imgui.Combo("Rack", racks.At(deviceRackChoice).Name, racks.Names(), &deviceRackChoice).Size(300),
+		imgui.InputFloat(&inputDevicePosition).Label("Position (U)").StepSize(0.5).Format("%.1f").Size(300),
+		imgui.Combo("Rack Face", faces[deviceFaceChoice], faces, &deviceFaceChoice).Size(300),
+		imgui.Combo("Platform", reg.Platforms.At(devicePlatformChoice).Name, reg.Platforms.Names(), &devicePlatformChoice).Size(300),
+		imgui.InputText(&inputDeviceAssetTag).Label("Asset Tag").Size(300),
+		imgui.Combo("Airflow", airflows[deviceAirflowChoice], airflows, &deviceAirflowChoice).Size(300),
+		imgui.TreeNode(fmt.Sprintf("Tags (%d)##deviceTags", len(tags))).Layout(tags...),
+		imgui.TreeNode(fmt.Sprintf("Custom Fields (%d)##deviceCustomFields", len(fields))).Layout(fields...),
+	}
+}
 
-		for i := 0; i < len(listOfDeviceTypeName); i++ {
-			//fmt.Println("Checking Type: " + listOfDeviceTypeName[i] + " == " + row[6])
-			if strings.Contains(listOfDeviceTypeName[i], row[6]) {
-				deviceTypeIndex = i
-				break
-			}
+// choiceNames labels the values of a combo, showing "" as "None".
+func choiceNames(values []string) []string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = v
+		if v == "" {
+			names[i] = "None"
 		}
+	}
+	return names
+}
 
-		deviceRoleIndex := 0
+// createVLAN creates a VLAN in NetBox, or plans it in dry-run mode.
+func createVLAN(vlanData VLANRequest) error {
+	if err := write(http.MethodPost, "/api/ipam/vlans/", vlanData, nil); err != nil {
+		return err
+	}
+	objects.Invalidate(kindVLANs)
+	return nil
+}
 
-		for i := 0; i < len(listOfDeviceRoleName); i++ {
-			//fmt.Println("Checking Role: " + listOfDeviceRoleName[i] + " == " + row[4])
-			if strings.Contains(listOfDeviceRoleName[i], row[4]) {
-				deviceRoleIndex = i
-				break
-			}
-		}
+// createDevice creates a device in NetBox, or plans it in dry-run mode.
+func createDevice(deviceData DeviceRequest) error {
+	if err := write(http.MethodPost, "/api/dcim/devices/", deviceData, nil); err != nil {
+		return err
+	}
+	objects.Invalidate(kindDevices)
+	return nil
+}
 
-		deviceSiteIndex := 0
+// connect points the API clients at the NetBox instance of profile,
+// authenticating with token.
+func connect(profile Profile, token string) error {
+	hc, err := profile.HTTPClient()
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
 
-		for i := 0; i < len(listOfDeviceSiteName); i++ {
-			//fmt.Println("Checking Site: " + listOfDeviceSiteName[i] + " == " + row[5])
-			if strings.Contains(listOfDeviceSiteName[i], row[5]) {
-				deviceSiteIndex = i
-				break
-			}
-		}
+	opts := []netbox.Option{
+		netbox.WithHTTPClient(hc),
+		netbox.WithRetryPolicy(profile.Retry.policy()),
+		netbox.WithConcurrency(profile.maxConcurrentRequests()),
+		netbox.WithRetryObserver(logRetry),
+	}
+	if profile.PageSize > 0 {
+		opts = append(opts, netbox.WithPageSize(profile.PageSize))
+	}
 
-		deviceTenantIndex := 0
+	apiClient = openapiclient.NewAPIClientFor(profile.URL, token)
+	apiClient.GetConfig().HTTPClient = hc
+	nbClient = netbox.NewClient(profile.URL, token, opts...)
+	activeProfile = profile
+	return nil
+}
 
-		for i := 0; i < len(listOfTenantName); i++ {
-			//fmt.Println("Checking Tenant: " + listOfTenantName[i] + " == " + row[2])
-			if strings.Contains(listOfTenantName[i], row[2]) {
-				deviceTenantIndex = i
-				break
-			}
+// useSavedToken fills the token field from the credential file.
+func useSavedToken() {
+	domain, passphrase := inputDomainLogIn, inputPassphraseLogIn
+	dataSvc.Go(func() {
+		token, err := savedToken(passphrase, domain)
+		if err != nil {
+			reportError("reading saved token", err)
+			return
 		}
+		if token == "" {
+			reportError("reading saved token", fmt.Errorf("no token saved for %s", domain))
+			return
+		}
+		dataSvc.Post(func() {
+			inputAPITokenLogIn = token
+		})
+	})
+}
 
-		deviceManufacturerIndex := 0
+// rememberLogIn saves the token typed in the login window, encrypted with
+// the passphrase, when the user asked for it.
+func rememberLogIn(domain, token, passphrase string) {
+	path, err := credentialPath()
+	if err == nil {
+		err = saveCredential(path, passphrase, domain, token)
+	}
+	if err != nil {
+		reportError("saving token", err)
+		return
+	}
+	logInfo("Token for %s saved", domain)
+}
 
-		for i := 0; i < len(listOfDeviceManufacturerName); i++ {
-			//fmt.Println("Checking Manu: " + listOfDeviceManufacturerName[i] + " == " + row[3])
-			if strings.Contains(listOfDeviceManufacturerName[i], row[3]) {
-				deviceManufacturerIndex = i
-				break
-			}
-		}
+// selectProfile fills the login form from the picked profile.
+func selectProfile() {
+	names := appConfig.Names()
+	profile, err := appConfig.Profile(names[profileChoice])
+	if err != nil {
+		reportError("selecting profile", err)
+		return
+	}
 
-		if deviceManufacturerIndex == 0 || deviceRoleIndex == 0 || deviceSiteIndex == 0 || deviceTenantIndex == 0 || deviceTypeIndex == 0 {
-			continue
-		}
+	inputDomainLogIn = profile.URL
+	token, err := profile.ResolveToken()
+	if err != nil {
+		logError("reading token", err)
+	}
+	inputAPITokenLogIn = token
+}
 
-		deviceData := DeviceRequest{
-			Name:         row[0],
-			DeviceType:   listOfDeviceType[deviceTypeIndex],
-			DeviceRole:   listOfDeviceRole[deviceRoleIndex],
-			Site:         listOfDeviceSite[deviceSiteIndex],
-			Tenant:       int(listOfTenant[deviceTenantIndex].Id),
-			Manufacturer: listOfDeviceManufacturer[deviceManufacturerIndex],
-			Status:       "active", // Device status
-			Serial:       row[1],   // Serial number
-		}
+func logIn() {
+	profile, err := appConfig.Profile(appConfig.Names()[profileChoice])
+	if err != nil {
+		reportError("logging in", err)
+		return
+	}
+	// The form may have been edited after picking the profile
+	profile.URL = inputDomainLogIn
+	if err := connect(profile, inputAPITokenLogIn); err != nil {
+		reportError("logging in", err)
+		return
+	}
+	domain, token := inputDomainLogIn, inputAPITokenLogIn
+	remember, passphrase := rememberToken, inputPassphraseLogIn
 
-		// Convert the device data to JSON
-		jsonData, err := json.Marshal(deviceData)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error marshalling device data: %v\n", err)
-			return
-		}
+	// Each NetBox instance has its own cache
+	switchCache := inputDomainLogIn != cacheDomain
+	cacheDomain = inputDomainLogIn
 
-		// NetBox API URL to create a new device
-		apiUrl := inputDomainLogIn + "/api/dcim/devices/"
+	loginFailure = ""
 
-		// Create an HTTP POST request with the device data
-		req, err := http.NewRequestWithContext(context.Background(), "POST", apiUrl, bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-			return
+	dataSvc.Go(func() {
+		if switchCache {
+			loadDiskCache()
 		}
 
-		req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-		req.Header.Set("Content-Type", "application/json")
-
-		// Send the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating device: %v\n", err)
+		sess, err := verifyLogin(ctx, domain, token)
+		if err == nil {
+			if remember {
+				rememberLogIn(domain, token, passphrase)
+			}
+			logInfo("Logged in to %s", sess.Label())
+			dataSvc.Post(func() {
+				currentSession = sess
+				offline.Store(false)
+				showLoggedIn = false
+				dataSvc.EnableSchedule(true)
+				requestRefresh()
+			})
 			return
 		}
-		defer resp.Body.Close()
 
-		// Check if the device was created successfully
-		if resp.StatusCode != http.StatusCreated {
-			// Print detailed error message from the response
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Fprintf(os.Stderr, "Error: HTTP %v\nResponse: %s\n", resp.Status, string(body))
+		if isUnreachable(err) && !objects.Empty() {
+			// NetBox cannot be reached, open read-only on the cached copy
+			logInfo("NetBox unreachable, using cached data: %v", err)
+			dataSvc.Post(func() {
+				currentSession = &session{Domain: domain, Offline: true, Started: time.Now()}
+				offline.Store(true)
+				showLoggedIn = false
+				dataSvc.EnableSchedule(false)
+				requestRefresh()
+			})
 			return
 		}
 
-		fmt.Println("Device created successfully!")
-	}
-
-	resetRefreshTimer()
+		logError("logging in", err)
+		dataSvc.Post(func() {
+			loginFailure = err.Error()
+		})
+	})
 }
 
-func logIn() {
-	apiClient = openapiclient.NewAPIClientFor(inputDomainLogIn, inputAPITokenLogIn)
-	//apiClient = openapiclient.NewAPIClientFor("https://netbox.cit.insea.io", "e3d318664caba8355bcea30a00237ae38c02b357")
-	resp, _, err := apiClient.StatusAPI.StatusRetrieve(ctx).Execute()
-	if err == nil {
-		showLoggedIn = false
-		resetRefreshTimer()
+// requestRefresh fully reloads the data for the screen currently shown
+func requestRefresh() {
+	sc := vlanScreen
+	if showDeviceScreen {
+		sc = deviceScreen
 	}
-	// response from `StatusRetrieve`: map[string]interface{}
-	fmt.Fprintf(os.Stdout, "Response from `StatusAPI.StatusRetrieve`: %v\n", resp)
+	dataSvc.SetActive(sc)
+	dataSvc.Refresh(sc, true)
 }
 
-func resetRefreshTimer() {
-	timer = 0.0
+// updateRefreshSchedules passes the auto-refresh settings to the data service
+func updateRefreshSchedules() {
+	dataSvc.SetSchedule(vlanScreen, time.Duration(vlanRefreshSeconds)*time.Second, vlanRefreshPaused)
+	dataSvc.SetSchedule(deviceScreen, time.Duration(deviceRefreshSeconds)*time.Second, deviceRefreshPaused)
 }
 
 func checkSubnet() {
-	url := inputDomainLogIn + "/api/ipam/prefixes/?limit=3000"
-
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
-	}
-
-	// Add the Authorization header with your API token
-	req.Header.Set("Authorization", "Token "+inputAPITokenLogIn)
-
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	prefixes, err := fetchPrefixes()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error making GET request: %v\n", err)
+		reportError("fetching prefixes", err)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response body: %v\n", err)
+	// Save the file
+	path := exportPath("prefixes.xlsx")
+	if err := exportPrefixes(prefixes, path); err != nil {
+		reportError("saving "+path, err)
 		return
 	}
 
-	var apiResponse ApiResponse
+	logInfo("Excel file created successfully: %s", path)
+}
 
-	// Unmarshal JSON data
-	err = json.Unmarshal([]byte(string(body)), &apiResponse)
-	if err != nil {
-		log.Fatalf("Error parsing JSON: %v\n", err)
-		return
-	}
+// fetchPrefixes lists every prefix, bypassing the cache.
+func fetchPrefixes() ([]Prefix, error) {
+	return listCached(kindPrefixes, "/api/ipam/prefixes/", 0, func(p Prefix) int { return p.ID })
+}
 
+// exportPrefixes writes the prefixes to the spreadsheet at path.
+func exportPrefixes(prefixes []Prefix, path string) error {
 	// Create a new Excel file
 	f := excel.NewFile()
 	sheetName := "Prefixes"
@@ -1234,7 +1126,7 @@ func checkSubnet() {
 	}
 
 	// Populate the sheet with data
-	for rowIndex, prefix := range apiResponse.Results {
+	for rowIndex, prefix := range prefixes {
 		row := rowIndex + 2 // Start from the second row
 		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), prefix.ID)
 		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), prefix.URL)
@@ -1251,31 +1143,43 @@ func checkSubnet() {
 	// Set the active sheet
 	f.SetActiveSheet(index)
 
-	// Save the file
-	if err := f.SaveAs("prefixes.xlsx"); err != nil {
-		log.Fatalf("Error saving file: %v\n", err)
-		return
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
 	}
-
-	fmt.Println("Excel file created successfully: prefixes.xlsx")
-	resetRefreshTimer()
+	return f.SaveAs(path)
 }
 
 func loop() {
+	// Pick up data loaded in the background since the last frame
+	dataSvc.ApplyUpdates()
+	reg := currentRegistry()
+
 	imgui.SingleWindow().Layout(
 		imgui.PrepareMsgbox(),
 		imgui.Row(
 			imgui.Button("Devices").OnClick(func() {
 				showDeviceScreen = true
-				resetRefreshTimer()
+				requestRefresh()
 			}),
-			imgui.Button("Check Subnet Used").OnClick(checkSubnet),
-			imgui.Button("Add New VLAN").OnClick(func() {
+			imgui.Button("Check Subnet Used").OnClick(func() {
+				dataSvc.Go(checkSubnet)
+			}),
+			imgui.Button("Add New VLAN").Disabled(writesDisabled()).OnClick(func() {
 				showEnterVLANWindow = true
 			}),
-			imgui.Button("Refresh VLAN List").OnClick(resetRefreshTimer),
+			imgui.Button("Refresh VLAN List").OnClick(requestRefresh),
+			imgui.Button("Log").OnClick(func() {
+				showLogWindow = true
+			}),
 			imgui.InputText(&inputIPAddressToSearchString).Label("Input VLAN name To Search").Size(300),
+			imgui.InputInt(&vlanRefreshSeconds).Label("Auto Refresh (s)").Size(100).OnChange(updateRefreshSchedules),
+			imgui.Checkbox("Pause", &vlanRefreshPaused).OnChange(updateRefreshSchedules),
+			loadingIndicator(),
+			imgui.Label(refreshedLabel(vlanTable.RefreshedAt())),
 		),
+		sessionBar(),
+		dryRunControls(),
+		offlineBanner(),
 		imgui.Row(
 			imgui.Label("IP Addresses"),
 			imgui.Table().Freeze(0, 1).FastMode(true).Rows(buildRows()...),
@@ -1287,16 +1191,28 @@ func loop() {
 			imgui.Row(
 				imgui.Button("IP Addresses").OnClick(func() {
 					showDeviceScreen = false
-					resetRefreshTimer()
+					requestRefresh()
 				}),
-				imgui.Button("Add New Device").OnClick(func() {
+				imgui.Button("Add New Device").Disabled(writesDisabled()).OnClick(func() {
 					showEnterDeviceWindow = true
 				}),
-				imgui.Button("Predict New Device Location").OnClick(predictDevice),
-				imgui.Button("Import New Devices From CSV").OnClick(importDeviceFromCSV),
-				imgui.Button("Refresh Device List").OnClick(resetRefreshTimer),
+				imgui.Button("Predict New Device Location").OnClick(func() {
+					dataSvc.Go(predictDevice)
+				}),
+				imgui.Button("Import New Devices From CSV").Disabled(writesDisabled()).OnClick(importDeviceFromCSV),
+				imgui.Button("Refresh Device List").OnClick(requestRefresh),
+				imgui.Button("Log").OnClick(func() {
+					showLogWindow = true
+				}),
 				imgui.InputText(&inputDeviceToSearchString).Label("Input Device To Search").Size(300),
+				imgui.InputInt(&deviceRefreshSeconds).Label("Auto Refresh (s)").Size(100).OnChange(updateRefreshSchedules),
+				imgui.Checkbox("Pause", &deviceRefreshPaused).OnChange(updateRefreshSchedules),
+				loadingIndicator(),
+				imgui.Label(refreshedLabel(deviceTable.RefreshedAt())),
 			),
+			sessionBar(),
+			dryRunControls(),
+			offlineBanner(),
 			imgui.Row(
 				imgui.Label("Devices"),
 				imgui.Table().Freeze(0, 1).FastMode(true).Rows(buildDeviceRows()...),
@@ -1306,9 +1222,22 @@ func loop() {
 
 	if showLoggedIn {
 		imgui.SingleWindow().IsOpen(&showLoggedIn).Flags(imgui.WindowFlagsNone).Layout(
+			imgui.Combo("Profile", appConfig.Names()[profileChoice], appConfig.Names(), &profileChoice).Size(300).OnChange(selectProfile),
 			imgui.InputText(&inputDomainLogIn).Label("Input Domain Address").Size(300),
-			imgui.InputText(&inputAPITokenLogIn).Label("Input API Token").Size(300),
+			imgui.InputText(&inputAPITokenLogIn).Label("Input API Token").Size(300).Flags(imgui.InputTextFlagsPassword),
+			imgui.Row(
+				imgui.InputText(&inputPassphraseLogIn).Label("Passphrase").Size(200).Flags(imgui.InputTextFlagsPassword),
+				imgui.Button("Use Saved Token").OnClick(useSavedToken),
+				imgui.Checkbox("Remember token", &rememberToken),
+			),
+			imgui.Checkbox("Keep an offline copy of the data", &diskCacheEnabled),
 			imgui.Button("Log In").OnClick(logIn),
+			imgui.Condition(loginFailure != "",
+				imgui.Style().SetColor(imgui.StyleColorText, color.RGBA{230, 80, 80, 255}).To(
+					imgui.Label("Login failed: "+loginFailure).Wrapped(true),
+				),
+				nil,
+			),
 		)
 	}
 
@@ -1317,7 +1246,7 @@ func loop() {
 			imgui.InputText(&inputVLANName).Label("Input VLAN Name").Size(300),
 			imgui.InputInt(&inputVLANVid).Label("Input VLAN ID").Size(300),
 			imgui.InputText(&inputVLANDesc).Label("Input Description").Size(700),
-			imgui.Combo("Tenants", listOfTenantName[tenantChoice], listOfTenantName, &tenantChoice).Size(300),
+			imgui.Combo("Tenants", reg.Tenants.At(tenantChoice).Name, reg.Tenants.Names(), &tenantChoice).Size(300),
 			//imgui.Combo("Sites", listOfSiteName[siteChoice], listOfSiteName, &siteChoice).Size(300),
 			imgui.Button("Add VLAN").OnClick(addVLANConfirmation),
 		)
@@ -1328,18 +1257,58 @@ func loop() {
 		imgui.Window("Device Input Window").IsOpen(&showEnterDeviceWindow).Flags(imgui.WindowFlagsNone).Layout(
 			imgui.InputText(&inputDeviceName).Label("Input Device Name").Size(300),
 			imgui.InputText(&inputDeviceSerialNumber).Label("Input Serial Number").Size(300),
-			imgui.Combo("Tenants", listOfTenantName[tenantChoice], listOfTenantName, &tenantChoice).Size(300),
-			imgui.Combo("Manufacturer", listOfDeviceManufacturerName[deviceManufacturerChoice], listOfDeviceManufacturerName, &deviceManufacturerChoice).Size(300),
-			imgui.Combo("Device Role", listOfDeviceRoleName[deviceRoleChoice], listOfDeviceRoleName, &deviceRoleChoice).Size(300),
-			imgui.Combo("Device Site", listOfDeviceSiteName[deviceSiteChoice], listOfDeviceSiteName, &deviceSiteChoice).Size(300),
-			imgui.Combo("Device Type", listOfDeviceTypeName[deviceTypeChoice], listOfDeviceTypeName, &deviceTypeChoice).Size(300),
+			imgui.Combo("Tenants", reg.Tenants.At(tenantChoice).Name, reg.Tenants.Names(), &tenantChoice).Size(300),
+			imgui.Combo("Manufacturer", reg.Manufacturers.At(deviceManufacturerChoice).Name, reg.Manufacturers.Names(), &deviceManufacturerChoice).Size(300),
+			imgui.Combo("Device Role", reg.DeviceRoles.At(deviceRoleChoice).Name, reg.DeviceRoles.Names(), &deviceRoleChoice).Size(300),
+			imgui.Combo("Device Site", reg.Sites.At(deviceSiteChoice).Name, reg.Sites.Names(), &deviceSiteChoice).Size(300).OnChange(func() {
+				// Locations and racks belong to the site
+				deviceLocationChoice, deviceRackChoice = 0, 0
+			}),
+			imgui.Combo("Device Type", reg.DeviceTypes.At(deviceTypeChoice).Name, reg.DeviceTypes.Names(), &deviceTypeChoice).Size(300),
+			imgui.Combo("Status", deviceStatuses[deviceStatusChoice], deviceStatuses, &deviceStatusChoice).Size(300),
+			deviceDetailWidgets(reg),
 			imgui.Button("Add Device").OnClick(addDeviceConfirmation),
 		)
 	}
+
+	errorWindow()
+	logWindow()
+	planWindow()
+	importWindow()
+	historyWindow()
 }
 
 func main() {
 	ctx = context.Background()
+
+	// Subcommands run headless, without a subcommand the GUI starts
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	// Load the profiles for the login window
+	path, err := configFile()
+	if err == nil {
+		appConfig, err = loadConfig(path)
+	}
+	if err != nil {
+		logError("loading config", err)
+		appConfig = defaultConfig()
+	}
+	if profile, err := appConfig.Profile(""); err == nil {
+		for i, name := range appConfig.Names() {
+			if name == profile.Name {
+				profileChoice = int32(i)
+			}
+		}
+	}
+	selectProfile()
+
+	dataSvc.Start()
 	wnd := imgui.NewMasterWindow("IP Storage System", 1280, 720, imgui.MasterWindowFlagsFloating)
 	wnd.Run(loop)
+
+	if cacheDomain != "" && !offline.Load() {
+		saveCache()
+	}
 }
@@ -0,0 +1,281 @@
+// Package netbox is a small REST client for the NetBox API. Every request the
+// app makes to NetBox goes through a Client so authentication, encoding and
+// error handling live in one place.
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Page is one page of a NetBox list endpoint.
+type Page[T any] struct {
+	Count    int     `json:"count"`
+	Next     *string `json:"next"`
+	Previous *string `json:"previous"`
+	Results  []T     `json:"results"`
+}
+
+// Client talks to a single NetBox instance.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	userAgent  string
+	pageSize   int
+	compat     atomic.Pointer[Compat]
+	retry      RetryPolicy
+	slots      chan struct{}
+	observer   func(RequestStats)
+	counters   counters
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send requests through hc instead of
+// http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// NewClient returns a client for the NetBox instance at baseURL
+// (e.g. "https://demo.netbox.dev") authenticating with token.
+func NewClient(baseURL, token string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: http.DefaultClient,
+		userAgent:  "netbox-data-app",
+		pageSize:   DefaultPageSize,
+		retry:      DefaultRetryPolicy,
+	}
+	c.compat.Store(NewCompat(Version{}))
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithVersion makes the client adapt requests to NetBox release v.
+func WithVersion(v Version) Option {
+	return func(c *Client) {
+		c.SetVersion(v)
+	}
+}
+
+// SetVersion tells the client which NetBox release it talks to once that is
+// known, e.g. after reading /api/status/.
+func (c *Client) SetVersion(v Version) {
+	c.compat.Store(NewCompat(v))
+}
+
+// Compat returns the adapter for the NetBox release the client talks to.
+func (c *Client) Compat() *Compat {
+	return c.compat.Load()
+}
+
+// BaseURL returns the NetBox address the client was created for.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// HTTPClient returns the http.Client used for requests.
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// Get fetches the object with the given ID from the endpoint at path
+// (e.g. "/api/dcim/devices/") and decodes it into out.
+func (c *Client) Get(ctx context.Context, path string, id int, out interface{}) error {
+	return c.Do(ctx, http.MethodGet, objectPath(path, id), nil, nil, out)
+}
+
+// List fetches a single page from the endpoint at path using the given
+// filters and decodes it into out, which is usually a *Page[T]. Use ListAll
+// or a Paginator to fetch every page.
+func (c *Client) List(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.Do(ctx, http.MethodGet, path, query, nil, out)
+}
+
+// Create POSTs body to the endpoint at path and decodes the created object
+// into out. out may be nil.
+func (c *Client) Create(ctx context.Context, path string, body, out interface{}) error {
+	return c.Do(ctx, http.MethodPost, path, nil, body, out)
+}
+
+// Update PATCHes the object with the given ID with the fields in body and
+// decodes the updated object into out. out may be nil.
+func (c *Client) Update(ctx context.Context, path string, id int, body, out interface{}) error {
+	return c.Do(ctx, http.MethodPatch, objectPath(path, id), nil, body, out)
+}
+
+// Delete removes the object with the given ID.
+func (c *Client) Delete(ctx context.Context, path string, id int) error {
+	return c.Do(ctx, http.MethodDelete, objectPath(path, id), nil, nil, nil)
+}
+
+// Do sends a request to NetBox. path is either an API path relative to the
+// base URL or an absolute URL such as a "next" link. body, when not nil, is
+// encoded as JSON; the response is decoded into out when out is not nil.
+// Field names are translated for older NetBox releases both ways. Transient
+// failures are retried according to the client's RetryPolicy.
+// Non-2xx responses are returned as *Error.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	reqURL := c.resolve(path, query)
+	compat := c.Compat()
+
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = compat.EncodeBody(reqURL, body)
+		if err != nil {
+			return fmt.Errorf("encoding %s %s: %w", method, reqURL, err)
+		}
+	}
+
+	c.counters.requests.Add(1)
+	stats := RequestStats{Method: method, URL: reqURL}
+
+	var respBody []byte
+	var err error
+	for {
+		stats.Attempts++
+		var header http.Header
+		respBody, header, stats.Status, err = c.attempt(ctx, method, reqURL, payload)
+		if err == nil || stats.Attempts >= c.retry.MaxAttempts || !retryable(method, stats.Status, err) {
+			break
+		}
+
+		wait := c.retry.backoff(stats.Attempts)
+		if d, ok := retryAfter(header.Get("Retry-After"), time.Now()); ok {
+			// Waiting longer than the policy allows is not worth it
+			if d > c.retry.MaxDelay {
+				break
+			}
+			wait = d
+		}
+		if serr := sleep(ctx, wait); serr != nil {
+			break
+		}
+		stats.Waited += wait
+		c.counters.retries.Add(1)
+	}
+
+	if err != nil {
+		c.counters.failures.Add(1)
+	}
+	if stats.Attempts > 1 && c.observer != nil {
+		stats.Err = err
+		c.observer(stats)
+	}
+	if err != nil {
+		return err
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	respBody, err = compat.DecodeBody(reqURL, respBody)
+	if err != nil {
+		return fmt.Errorf("decoding %s %s: %w", method, reqURL, err)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decoding %s %s: %w", method, reqURL, err)
+	}
+	return nil
+}
+
+// attempt sends a request once and returns the response body, headers and
+// status. The status is 0 when no response was received.
+func (c *Client) attempt(ctx context.Context, method, reqURL string, payload []byte) ([]byte, http.Header, int, error) {
+	if err := c.acquire(ctx); err != nil {
+		return nil, nil, 0, fmt.Errorf("%s %s: %w", method, reqURL, err)
+	}
+	defer c.release()
+
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reader)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("creating %s %s: %w", method, reqURL, err)
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%s %s: %w", method, reqURL, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.Header, 0, fmt.Errorf("reading %s %s: %w", method, reqURL, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return respBody, resp.Header, resp.StatusCode, newError(method, reqURL, resp.StatusCode, respBody)
+	}
+	return respBody, resp.Header, resp.StatusCode, nil
+}
+
+// rebase points an absolute link NetBox returned, such as a "next" link, at
+// the client's own scheme and host. Behind a proxy NetBox may name another
+// one, e.g. http:// where the client uses https://, and the token must not
+// follow it there.
+func (c *Client) rebase(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || !u.IsAbs() {
+		return link
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return link
+	}
+	u.Scheme, u.Host, u.User = base.Scheme, base.Host, nil
+	return u.String()
+}
+
+// resolve turns an API path plus filters into an absolute URL.
+func (c *Client) resolve(path string, query url.Values) string {
+	reqURL := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		reqURL = c.baseURL + "/" + strings.TrimLeft(path, "/")
+	}
+	if len(query) == 0 {
+		return reqURL
+	}
+	if strings.Contains(reqURL, "?") {
+		return reqURL + "&" + query.Encode()
+	}
+	return reqURL + "?" + query.Encode()
+}
+
+func objectPath(path string, id int) string {
+	return fmt.Sprintf("%s/%d/", strings.TrimRight(path, "/"), id)
+}
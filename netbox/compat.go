@@ -0,0 +1,205 @@
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version is a NetBox release number.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses the "netbox-version" reported by /api/status/, e.g.
+// "4.1.3" or "3.7.8-Docker-2.8.0".
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+ "); i >= 0 {
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid NetBox version %q", s)
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid NetBox version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// Less reports whether v is an older release than o.
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// IsZero reports whether the version is unknown.
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// fieldRename is a field NetBox renamed. Releases before Since only know the
+// Legacy name; the app always uses the current name.
+type fieldRename struct {
+	// Path is the endpoint the field belongs to.
+	Path    string
+	Current string
+	Legacy  string
+	Since   Version
+}
+
+// Renamed fields the app reads or writes. NetBox 3.6 added "role" to devices
+// next to "device_role", which 4.0 removed. 4.0 also renamed the
+// "content_types" of custom fields to "object_types".
+var fieldRenames = []fieldRename{
+	{Path: "/api/dcim/devices/", Current: "role", Legacy: "device_role", Since: Version{3, 6, 0}},
+	{Path: "/api/extras/custom-fields/", Current: "object_types", Legacy: "content_types", Since: Version{4, 0, 0}},
+}
+
+// Compat adapts requests and responses to the NetBox release the client
+// talks to, so the rest of the app only deals with the current field names.
+type Compat struct {
+	version Version
+	renames []fieldRename
+}
+
+// NewCompat returns the adapter for NetBox release v. A zero version is
+// treated as the newest release.
+func NewCompat(v Version) *Compat {
+	c := &Compat{version: v}
+	if v.IsZero() {
+		return c
+	}
+	for _, r := range fieldRenames {
+		if v.Less(r.Since) {
+			c.renames = append(c.renames, r)
+		}
+	}
+	return c
+}
+
+// Version returns the NetBox release the adapter was made for.
+func (c *Compat) Version() Version {
+	return c.version
+}
+
+// FieldName returns what the field the app calls name is called by the
+// endpoint at path on this release.
+func (c *Compat) FieldName(path, name string) string {
+	for _, r := range c.renamesFor(path) {
+		if r.Current == name {
+			return r.Legacy
+		}
+	}
+	return name
+}
+
+func (c *Compat) renamesFor(path string) []fieldRename {
+	if c == nil || len(c.renames) == 0 {
+		return nil
+	}
+	if u, err := url.Parse(path); err == nil {
+		path = u.Path
+	}
+
+	var matched []fieldRename
+	for _, r := range c.renames {
+		if strings.Contains(path, r.Path) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// EncodeBody encodes body for the endpoint at path, renaming fields to what
+// this release expects. body may be an object or a list of objects.
+func (c *Compat) EncodeBody(path string, body interface{}) ([]byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	renames := c.renamesFor(path)
+	if len(renames) == 0 {
+		return payload, nil
+	}
+	return rewrite(payload, func(obj map[string]json.RawMessage) {
+		for _, r := range renames {
+			move(obj, r.Current, r.Legacy)
+		}
+	})
+}
+
+// DecodeBody rewrites a response from the endpoint at path to the current
+// field names. Both single objects and list pages are handled.
+func (c *Compat) DecodeBody(path string, data []byte) ([]byte, error) {
+	renames := c.renamesFor(path)
+	if len(renames) == 0 {
+		return data, nil
+	}
+	return rewrite(data, func(obj map[string]json.RawMessage) {
+		for _, r := range renames {
+			move(obj, r.Legacy, r.Current)
+		}
+	})
+}
+
+// rewrite applies fn to every object in data: the object itself, each
+// element of a list, or each element of a page's "results".
+func rewrite(data []byte, fn func(map[string]json.RawMessage)) ([]byte, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var list []map[string]json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, obj := range list {
+			fn(obj)
+		}
+		return json.Marshal(list)
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if results, ok := obj["results"]; ok {
+		rewritten, err := rewrite(results, fn)
+		if err != nil {
+			return nil, err
+		}
+		obj["results"] = rewritten
+		return json.Marshal(obj)
+	}
+	fn(obj)
+	return json.Marshal(obj)
+}
+
+// move renames a field unless the target name is already set.
+func move(obj map[string]json.RawMessage, from, to string) {
+	v, ok := obj[from]
+	if !ok {
+		return
+	}
+	if _, exists := obj[to]; !exists {
+		obj[to] = v
+	}
+	delete(obj, from)
+}
@@ -0,0 +1,115 @@
+package netbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Error is returned for any non-2xx response from NetBox.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Detail is NetBox's "detail" message, e.g. "Invalid token".
+	Detail string
+	// Fields holds per-field validation messages from a 400 response.
+	Fields map[string][]string
+	// Body is the raw response body.
+	Body string
+}
+
+func newError(method, url string, status int, body []byte) *Error {
+	e := &Error{
+		Method:     method,
+		URL:        url,
+		StatusCode: status,
+		Body:       string(body),
+	}
+
+	// NetBox answers with {"detail": "..."} for most errors and with a map of
+	// field name to messages for validation failures. Bulk requests return a
+	// list of such maps, one per object.
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err == nil {
+		e.collect("", obj)
+		return e
+	}
+	var list []map[string]interface{}
+	if err := json.Unmarshal(body, &list); err == nil {
+		for i, item := range list {
+			e.collect(fmt.Sprintf("[%d].", i), item)
+		}
+	}
+	return e
+}
+
+func (e *Error) collect(prefix string, obj map[string]interface{}) {
+	for key, value := range obj {
+		if key == "detail" {
+			if s, ok := value.(string); ok {
+				e.Detail = s
+				continue
+			}
+		}
+		if e.Fields == nil {
+			e.Fields = make(map[string][]string)
+		}
+		switch v := value.(type) {
+		case string:
+			e.Fields[prefix+key] = append(e.Fields[prefix+key], v)
+		case []interface{}:
+			for _, m := range v {
+				e.Fields[prefix+key] = append(e.Fields[prefix+key], fmt.Sprint(m))
+			}
+		default:
+			e.Fields[prefix+key] = append(e.Fields[prefix+key], fmt.Sprint(v))
+		}
+	}
+}
+
+// Message returns the NetBox validation message in a single line.
+func (e *Error) Message() string {
+	var parts []string
+	if e.Detail != "" {
+		parts = append(parts, e.Detail)
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, k+": "+strings.Join(e.Fields[k], " "))
+	}
+	if len(parts) == 0 {
+		return strings.TrimSpace(e.Body)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *Error) Error() string {
+	msg := e.Message()
+	if msg == "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), msg)
+}
+
+// StatusCode returns the HTTP status carried by err, or 0 if err is not a
+// NetBox error.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a 404 from NetBox.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
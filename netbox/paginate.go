@@ -0,0 +1,148 @@
+package netbox
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// DefaultPageSize is the number of objects requested per page when neither
+// the client nor the call overrides it. NetBox caps pages at MAX_PAGE_SIZE
+// (1000 by default).
+const DefaultPageSize = 500
+
+// WithPageSize sets the default page size used by ListAll and Paginator.
+func WithPageSize(n int) Option {
+	return func(c *Client) {
+		c.pageSize = n
+	}
+}
+
+// ListOptions controls how a list endpoint is walked.
+type ListOptions struct {
+	// PageSize is the number of objects per request. Zero uses the client
+	// default.
+	PageSize int
+	// MaxItems stops the walk once this many objects have been collected.
+	// Zero means no limit.
+	MaxItems int
+}
+
+// Paginator walks a NetBox list endpoint page by page by following the
+// "next" link of each response.
+type Paginator[T any] struct {
+	client  *Client
+	next    string
+	query   url.Values
+	opts    ListOptions
+	fetched int
+	count   int
+	done    bool
+}
+
+// NewPaginator returns a paginator over the endpoint at path filtered by
+// query. No request is made until Next is called.
+func NewPaginator[T any](c *Client, path string, query url.Values, opts ListOptions) *Paginator[T] {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = append([]string(nil), v...)
+	}
+	size := opts.PageSize
+	if size <= 0 {
+		size = c.pageSize
+	}
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	if opts.MaxItems > 0 && opts.MaxItems < size {
+		size = opts.MaxItems
+	}
+	q.Set("limit", strconv.Itoa(size))
+	q.Del("offset")
+
+	return &Paginator[T]{
+		client: c,
+		next:   path,
+		query:  q,
+		opts:   opts,
+	}
+}
+
+// More reports whether another page can be fetched.
+func (p *Paginator[T]) More() bool {
+	return !p.done
+}
+
+// Count returns the total number of objects NetBox reported for the query.
+// It is only known after the first call to Next.
+func (p *Paginator[T]) Count() int {
+	return p.count
+}
+
+// Next fetches the next page.
+func (p *Paginator[T]) Next(ctx context.Context) ([]T, error) {
+	if p.done {
+		return nil, nil
+	}
+
+	var page Page[T]
+	if err := p.client.List(ctx, p.next, p.query, &page); err != nil {
+		return nil, err
+	}
+	p.count = page.Count
+
+	// The next link already carries the filters and the new offset.
+	p.query = nil
+	if page.Next == nil || *page.Next == "" || len(page.Results) == 0 {
+		p.done = true
+	} else {
+		p.next = p.client.rebase(*page.Next)
+	}
+
+	results := page.Results
+	if p.opts.MaxItems > 0 && p.fetched+len(results) >= p.opts.MaxItems {
+		results = results[:p.opts.MaxItems-p.fetched]
+		p.done = true
+	}
+	p.fetched += len(results)
+	return results, nil
+}
+
+// ListAll fetches every object from the endpoint at path, following the
+// "next" link until the list is exhausted or opts.MaxItems is reached.
+func ListAll[T any](ctx context.Context, c *Client, path string, query url.Values, opts ListOptions) ([]T, error) {
+	p := NewPaginator[T](c, path, query, opts)
+
+	var all []T
+	for p.More() {
+		page, err := p.Next(ctx)
+		if err != nil {
+			return all, err
+		}
+		if all == nil {
+			size := p.Count()
+			if opts.MaxItems > 0 && opts.MaxItems < size {
+				size = opts.MaxItems
+			}
+			all = make([]T, 0, size)
+		}
+		all = append(all, page...)
+	}
+	return all, nil
+}
+
+// Count returns the number of objects the endpoint at path holds for the
+// given filters without fetching them.
+func Count(ctx context.Context, c *Client, path string, query url.Values) (int, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = append([]string(nil), v...)
+	}
+	q.Set("limit", "1")
+
+	var page Page[struct{}]
+	if err := c.List(ctx, path, q, &page); err != nil {
+		return 0, err
+	}
+	return page.Count, nil
+}
@@ -0,0 +1,91 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+type testObject struct {
+	ID int `json:"id"`
+}
+
+// listServer serves total objects with IDs from 1 as a NetBox list endpoint
+// that honours limit and offset, and counts the requests in pages. Next
+// links name nextHost, or the server itself when it is empty.
+func listServer(t *testing.T, total int, nextHost string, pages *atomic.Int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages.Add(1)
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			t.Errorf("request %s has no limit", r.URL)
+			limit = total
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+		page := Page[testObject]{Count: total, Results: []testObject{}}
+		for id := offset + 1; id <= min(offset+limit, total); id++ {
+			page.Results = append(page.Results, testObject{ID: id})
+		}
+		if offset+limit < total {
+			host := nextHost
+			if host == "" {
+				host = r.Host
+			}
+			next := fmt.Sprintf("http://%s%s?limit=%d&offset=%d", host, r.URL.Path, limit, offset+limit)
+			page.Next = &next
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestListAll(t *testing.T) {
+	tests := []struct {
+		name      string
+		pageSize  int
+		maxItems  int
+		nextHost  string
+		wantItems int
+		wantPages int32
+	}{
+		{"every page", 3, 0, "", 7, 3},
+		{"MaxItems stops mid-page", 3, 5, "", 5, 2},
+		{"MaxItems at a page boundary", 3, 3, "", 3, 1},
+		{"MaxItems below the page size", 3, 2, "", 2, 1},
+		{"MaxItems above the count", 3, 20, "", 7, 3},
+		{"next links to another host", 3, 0, "netbox.invalid:8080", 7, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages atomic.Int32
+			srv := listServer(t, 7, tt.nextHost, &pages)
+			defer srv.Close()
+
+			c := NewClient(srv.URL, "token")
+			objects, err := ListAll[testObject](context.Background(), c, "/api/dcim/devices/", nil, ListOptions{PageSize: tt.pageSize, MaxItems: tt.maxItems})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(objects) != tt.wantItems {
+				t.Fatalf("got %d objects, want %d", len(objects), tt.wantItems)
+			}
+			for i, o := range objects {
+				if o.ID != i+1 {
+					t.Errorf("object %d has ID %d, want %d", i, o.ID, i+1)
+				}
+			}
+			if got := pages.Load(); got != tt.wantPages {
+				t.Errorf("fetched %d pages, want %d", got, tt.wantPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package netbox
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Idempotent requests
+// (GET, HEAD, OPTIONS, PUT, DELETE) are retried on network errors and on 429,
+// 502, 503 and 504. Other requests are only retried on 429, which NetBox
+// sends before doing any work.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries; 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles with every
+	// attempt, with full jitter, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy says otherwise.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// RequestStats describes a request that needed more than one attempt.
+type RequestStats struct {
+	Method   string
+	URL      string
+	Attempts int
+	// Waited is the total time spent backing off.
+	Waited time.Duration
+	// Status is the HTTP status of the last attempt, 0 on network errors.
+	Status int
+	// Err is the final error, nil when a retry succeeded.
+	Err error
+}
+
+// Stats are the totals of every request a client has made.
+type Stats struct {
+	Requests int64
+	Retries  int64
+	Failures int64
+}
+
+// WithRetryPolicy sets how failed requests are retried.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		if p.MaxAttempts < 1 {
+			p.MaxAttempts = 1
+		}
+		c.retry = p
+	}
+}
+
+// WithConcurrency limits how many requests the client has in flight at once.
+// Zero means no limit.
+func WithConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.slots = make(chan struct{}, n)
+		} else {
+			c.slots = nil
+		}
+	}
+}
+
+// WithRetryObserver makes the client call fn after every request that was
+// retried, whether it eventually succeeded or not.
+func WithRetryObserver(fn func(RequestStats)) Option {
+	return func(c *Client) {
+		c.observer = fn
+	}
+}
+
+// counters backs Client.Stats.
+type counters struct {
+	requests atomic.Int64
+	retries  atomic.Int64
+	failures atomic.Int64
+}
+
+// Stats returns the request totals of the client.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Requests: c.counters.requests.Load(),
+		Retries:  c.counters.retries.Load(),
+		Failures: c.counters.failures.Load(),
+	}
+}
+
+// acquire waits for a free request slot.
+func (c *Client) acquire(ctx context.Context) error {
+	if c.slots == nil {
+		return nil
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) release() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether an attempt that ended with status (0 for a
+// network error) may be tried again.
+func retryable(method string, status int, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if !idempotent(method) {
+		return false
+	}
+	switch status {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait before the given retry (1 for the first).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay << (retry - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
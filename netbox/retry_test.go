@@ -0,0 +1,117 @@
+package netbox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reply is one canned response of a scripted server.
+type reply struct {
+	status     int
+	retryAfter string
+}
+
+// scriptedServer answers the n-th request with replies[n], repeating the
+// last reply once the script runs out, and counts the requests in attempts.
+func scriptedServer(replies []reply, attempts *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(attempts.Add(1)) - 1
+		rep := replies[min(n, len(replies)-1)]
+		if rep.retryAfter != "" {
+			w.Header().Set("Retry-After", rep.retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rep.status)
+		w.Write([]byte(`{}`))
+	}))
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	tests := []struct {
+		name         string
+		method       string
+		replies      []reply
+		wantAttempts int32
+		wantStatus   int
+	}{
+		{"GET retried on 503", http.MethodGet, []reply{{503, ""}, {503, ""}, {200, ""}}, 3, 0},
+		{"GET gives up after MaxAttempts", http.MethodGet, []reply{{503, ""}}, 3, 503},
+		{"GET not retried on 400", http.MethodGet, []reply{{400, ""}, {200, ""}}, 1, 400},
+		{"POST not retried on 503", http.MethodPost, []reply{{503, ""}, {201, ""}}, 1, 503},
+		{"POST retried on 429", http.MethodPost, []reply{{429, ""}, {201, ""}}, 2, 0},
+		{"Retry-After within MaxDelay", http.MethodGet, []reply{{503, "0"}, {200, ""}}, 2, 0},
+		{"Retry-After above MaxDelay aborts", http.MethodGet, []reply{{503, "60"}, {200, ""}}, 1, 503},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := scriptedServer(tt.replies, &attempts)
+			defer srv.Close()
+
+			var observed []RequestStats
+			c := NewClient(srv.URL, "token", WithRetryPolicy(policy), WithRetryObserver(func(s RequestStats) {
+				observed = append(observed, s)
+			}))
+			var body interface{}
+			if tt.method == http.MethodPost {
+				body = map[string]string{"name": "new"}
+			}
+			err := c.Do(context.Background(), tt.method, "/api/dcim/devices/", nil, body, nil)
+
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if got := StatusCode(err); got != tt.wantStatus {
+				t.Errorf("status of error %v = %d, want %d", err, got, tt.wantStatus)
+			}
+			if tt.wantAttempts > 1 && (len(observed) != 1 || observed[0].Attempts != int(tt.wantAttempts)) {
+				t.Errorf("observer saw %+v, want one request with %d attempts", observed, tt.wantAttempts)
+			}
+			if tt.wantAttempts == 1 && len(observed) != 0 {
+				t.Errorf("observer saw %+v for a request that was not retried", observed)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token", WithConcurrency(limit))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Do(context.Background(), http.MethodGet, "/api/status/", nil, nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got != limit {
+		t.Errorf("peak requests in flight = %d, want %d", got, limit)
+	}
+}
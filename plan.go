@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// dryRun makes write add changes to the plan instead of sending them to
+// NetBox. dryRunChecked backs the GUI checkbox.
+var dryRun atomic.Bool
+var dryRunChecked bool
+var showPlanWindow bool
+
+// plannedWrite is a change dry-run mode held back.
+type plannedWrite struct {
+	Time     time.Time       `json:"time"`
+	Method   string          `json:"method"`
+	Endpoint string          `json:"endpoint"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	// Problems found validating the payload; empty when it looks valid.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// writePlan collects the writes of a dry run for review.
+type writePlan struct {
+	mu     sync.Mutex
+	writes []plannedWrite
+}
+
+var plan = &writePlan{}
+
+func (p *writePlan) add(w plannedWrite) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writes = append(p.writes, w)
+}
+
+// Writes returns a copy of the planned writes, oldest first.
+func (p *writePlan) Writes() []plannedWrite {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]plannedWrite(nil), p.writes...)
+}
+
+func (p *writePlan) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writes = nil
+}
+
+// Problems returns how many planned writes failed validation.
+func (p *writePlan) Problems() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, w := range p.writes {
+		if len(w.Problems) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the plan as JSON to path.
+func (p *writePlan) Save(path string) error {
+	data, err := json.MarshalIndent(p.Writes(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// validator is implemented by request bodies that can be checked before
+// they are sent.
+type validator interface {
+	Validate() []string
+}
+
+// write sends a change to NetBox and decodes the response into out. In
+// dry-run mode nothing is sent: the request is validated and added to the
+// plan, and out is left untouched.
+func write(method, path string, body, out interface{}) error {
+	if !dryRun.Load() {
+		return nbClient.Do(ctx, method, path, nil, body, out)
+	}
+
+	w := plannedWrite{
+		Time:     time.Now(),
+		Method:   method,
+		Endpoint: strings.TrimSuffix(nbClient.BaseURL(), "/") + path,
+	}
+	if body != nil {
+		// Encode the body the way the client would, so the plan shows the
+		// field names this NetBox release expects
+		payload, err := nbClient.Compat().EncodeBody(path, body)
+		if err != nil {
+			return fmt.Errorf("encoding %s %s: %w", method, path, err)
+		}
+		w.Payload = payload
+	}
+	if v, ok := body.(validator); ok {
+		w.Problems = v.Validate()
+	}
+	plan.add(w)
+
+	if len(w.Problems) > 0 {
+		logInfo("Dry run: planned %s %s with problems: %s", method, path, strings.Join(w.Problems, "; "))
+	} else {
+		logInfo("Dry run: planned %s %s", method, path)
+	}
+	return nil
+}
+
+// logWrite logs that a change was made, or only planned in dry-run mode.
+func logWrite(format string, args ...interface{}) {
+	if dryRun.Load() {
+		logInfo("Dry run, not sent: "+format, args...)
+		return
+	}
+	logInfo(format, args...)
+}
+
+// writesDisabled reports whether the buttons that change NetBox must be
+// disabled. A dry run never writes, so read-only sessions may plan changes.
+func writesDisabled() bool {
+	return currentSession.ReadOnly() && !dryRun.Load()
+}
+
+// Device statuses NetBox accepts
+var deviceStatuses = []string{"offline", "active", "planned", "staged", "failed", "inventory", "decommissioning"}
+
+// Rack faces and airflow directions NetBox accepts; "" leaves them unset
+var deviceFaces = []string{"", "front", "rear"}
+var deviceAirflows = []string{"", "front-to-rear", "rear-to-front", "left-to-right", "right-to-left", "side-to-rear", "passive", "mixed"}
+
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the VLAN against the rules NetBox applies.
+func (v VLANRequest) Validate() []string {
+	var problems []string
+	if v.Vid < 1 || v.Vid > 4094 {
+		problems = append(problems, fmt.Sprintf("VLAN ID %d is not between 1 and 4094", v.Vid))
+	}
+	if strings.TrimSpace(v.Name) == "" {
+		problems = append(problems, "name is required")
+	} else if len(v.Name) > 64 {
+		problems = append(problems, "name is longer than 64 characters")
+	}
+	if len(v.Description) > 200 {
+		problems = append(problems, "description is longer than 200 characters")
+	}
+	return problems
+}
+
+// Validate checks the device against the rules NetBox applies.
+func (d DeviceRequest) Validate() []string {
+	var problems []string
+	if len(d.Name) > 64 {
+		problems = append(problems, "name is longer than 64 characters")
+	}
+	if d.DeviceType == 0 {
+		problems = append(problems, "device type is required")
+	}
+	if d.DeviceRole == 0 {
+		problems = append(problems, "device role is required")
+	}
+	if d.Site == 0 {
+		problems = append(problems, "site is required")
+	}
+	if len(d.Serial) > 50 {
+		problems = append(problems, "serial is longer than 50 characters")
+	}
+
+	if len(d.AssetTag) > 50 {
+		problems = append(problems, "asset tag is longer than 50 characters")
+	}
+	if !oneOf(d.Status, deviceStatuses) {
+		problems = append(problems, fmt.Sprintf("unknown status %q", d.Status))
+	}
+	if !oneOf(d.Face, deviceFaces) {
+		problems = append(problems, fmt.Sprintf("unknown rack face %q, want front or rear", d.Face))
+	}
+	if !oneOf(d.Airflow, deviceAirflows) {
+		problems = append(problems, fmt.Sprintf("unknown airflow %q", d.Airflow))
+	}
+
+	// A position is a U of a rack face, counted in half units
+	if d.Rack == 0 && (d.Position != 0 || d.Face != "") {
+		problems = append(problems, "rack position and face need a rack")
+	}
+	if d.Position != 0 && d.Face == "" {
+		problems = append(problems, "rack position needs a face")
+	}
+	if d.Position < 0 || d.Position*2 != float64(int(d.Position*2)) {
+		problems = append(problems, fmt.Sprintf("rack position %g is not a whole or half U", d.Position))
+	}
+	return problems
+}
+
+// setDryRun applies the GUI checkbox.
+func setDryRun() {
+	dryRun.Store(dryRunChecked)
+	if dryRunChecked {
+		logInfo("Dry run on: changes are collected in the plan instead of being sent to NetBox")
+		showPlanWindow = true
+	} else {
+		logInfo("Dry run off: changes are sent to NetBox")
+	}
+}
+
+// dryRunControls are the toolbar widgets of dry-run mode.
+func dryRunControls() imgui.Widget {
+	return imgui.Row(
+		imgui.Checkbox("Dry run", &dryRunChecked).OnChange(setDryRun),
+		imgui.Button(fmt.Sprintf("Plan (%d)", len(plan.Writes()))).OnClick(func() {
+			showPlanWindow = true
+		}),
+	)
+}
+
+// savePlan writes the plan next to the other exports.
+func savePlan() {
+	path := exportPath("dry-run-plan.json")
+	if err := plan.Save(path); err != nil {
+		reportError("saving plan", err)
+		return
+	}
+	logInfo("Plan saved to %s", path)
+}
+
+func planWindow() {
+	if !showPlanWindow {
+		return
+	}
+
+	writes := plan.Writes()
+	rows := make([]*imgui.TableRowWidget, 0, len(writes))
+	for i, w := range writes {
+		payload := string(w.Payload)
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, w.Payload, "", "  "); err == nil {
+			payload = indented.String()
+		}
+		status := "OK"
+		if len(w.Problems) > 0 {
+			status = strings.Join(w.Problems, "\n")
+		}
+		rows = append(rows, imgui.TableRow(
+			imgui.Label(fmt.Sprint(i+1)),
+			imgui.Label(w.Method+" "+w.Endpoint).Wrapped(true),
+			imgui.Label(payload).Wrapped(true),
+			imgui.Label(status).Wrapped(true),
+		))
+	}
+
+	summary := fmt.Sprintf("%d planned writes, %d with problems", len(writes), plan.Problems())
+	if len(writes) == 0 {
+		summary = "Nothing planned. Turn on Dry run and add or import objects to build a plan."
+	}
+
+	imgui.Window("Dry-Run Plan").IsOpen(&showPlanWindow).Size(900, 400).Layout(
+		imgui.Row(
+			imgui.Button("Clear").OnClick(plan.Clear),
+			imgui.Button("Save").OnClick(savePlan),
+			imgui.Label(summary),
+		),
+		imgui.Table().Columns(
+			imgui.TableColumn("#").Flags(imgui.TableColumnFlagsWidthFixed).InnerWidthOrWeight(30),
+			imgui.TableColumn("Request"),
+			imgui.TableColumn("Payload"),
+			imgui.TableColumn("Validation"),
+		).Rows(rows...),
+	)
+}
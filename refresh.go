@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"main/netbox-data-app/netbox"
+)
+
+const (
+	// Auto-refresh interval used until the user picks another one
+	defaultRefreshInterval = 60 * time.Second
+
+	// Incremental refreshes cannot see deleted objects when the total count
+	// happens to stay the same, so every screen is fully reloaded this often
+	fullRefreshEvery = 15 * time.Minute
+
+	// Margin subtracted from the last refresh time when asking NetBox for
+	// changed objects, to cover clock skew between us and the server
+	clockSkewMargin = time.Minute
+)
+
+// refreshSchedule is the auto-refresh setting of one screen.
+type refreshSchedule struct {
+	interval time.Duration
+	paused   bool
+	last     time.Time
+}
+
+// SetSchedule changes how often a screen refreshes itself. A zero interval
+// disables auto-refresh for that screen.
+func (s *dataService) SetSchedule(sc screen, interval time.Duration, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.schedules[sc].interval = interval
+	s.schedules[sc].paused = paused
+}
+
+// EnableSchedule turns auto-refresh on once there is a NetBox to talk to.
+func (s *dataService) EnableSchedule(enabled bool) {
+	s.enabled.Store(enabled)
+}
+
+// schedule queues an incremental refresh of the active screen whenever its
+// interval has elapsed.
+func (s *dataService) schedule() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !s.enabled.Load() {
+			continue
+		}
+
+		sc := screen(s.active.Load())
+
+		s.mu.Lock()
+		sch := s.schedules[sc]
+		due := !sch.paused && sch.interval > 0 && now.Sub(sch.last) >= sch.interval
+		s.mu.Unlock()
+
+		if due {
+			s.Refresh(sc, false)
+		}
+	}
+}
+
+func (s *vlanSnapshot) needsFullRefresh() bool {
+	return s == nil || time.Since(s.FullRefresh) >= fullRefreshEvery
+}
+
+func (s *deviceSnapshot) needsFullRefresh() bool {
+	return s == nil || time.Since(s.FullRefresh) >= fullRefreshEvery
+}
+
+// fetchChanged lists the objects at path. Given the previous result and the
+// time it was loaded, it only fetches objects whose last_updated is newer and
+// merges them in. When the merged list no longer matches NetBox's total
+// count, objects were deleted and everything is fetched again, as it is when
+// our own writes invalidated the kind. The result is kept in the object cache
+// and served from there when the app runs offline. The second result reports
+// whether a full listing was made.
+func fetchChanged[T any](kind, path string, prev []T, since time.Time, id func(T) int) ([]T, bool, error) {
+	if offline.Load() {
+		cached, ok := cacheList[T](objects, kind)
+		if !ok {
+			return nil, false, fmt.Errorf("%s is not in the offline cache", kind)
+		}
+		return cached, true, nil
+	}
+
+	list, full, err := fetchFromNetBox(path, prev, since, objects.Stale(kind), id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := cacheStore(objects, kind, list, id); err != nil {
+		logError("caching "+kind, err)
+	}
+	return list, full, nil
+}
+
+func fetchFromNetBox[T any](path string, prev []T, since time.Time, stale bool, id func(T) int) ([]T, bool, error) {
+	if prev == nil || since.IsZero() || stale {
+		all, err := netbox.ListAll[T](ctx, nbClient, path, nil, netbox.ListOptions{})
+		return all, true, err
+	}
+
+	query := url.Values{}
+	query.Set("last_updated__gte", since.Add(-clockSkewMargin).UTC().Format(time.RFC3339))
+
+	changed, err := netbox.ListAll[T](ctx, nbClient, path, query, netbox.ListOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+
+	total, err := netbox.Count(ctx, nbClient, path, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	merged := mergeByID(prev, changed, id)
+	if len(merged) != total {
+		all, err := netbox.ListAll[T](ctx, nbClient, path, nil, netbox.ListOptions{})
+		return all, true, err
+	}
+	return merged, false, nil
+}
+
+// mergeByID returns a copy of list with the changed objects replaced or
+// appended, matched by ID.
+func mergeByID[T any](list, changed []T, id func(T) int) []T {
+	merged := make([]T, len(list), len(list)+len(changed))
+	copy(merged, list)
+
+	index := make(map[int]int, len(merged))
+	for i, item := range merged {
+		index[id(item)] = i
+	}
+
+	for _, item := range changed {
+		if i, ok := index[id(item)]; ok {
+			merged[i] = item
+			continue
+		}
+		index[id(item)] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
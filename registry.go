@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sahilm/fuzzy"
+)
+
+// refItem is one reference object offered in a combo or matched by the
+// importer.
+type refItem struct {
+	ID   int
+	Name string
+	Slug string
+	// Site is the site the object belongs to, 0 for objects of no site.
+	Site int
+}
+
+// refList is an immutable lookup over one kind of reference object. Items are
+// sorted by name with "None" (ID 0) always at index 0, so a combo selection
+// can be used as an index directly.
+type refList struct {
+	items []refItem
+	names []string
+	byID  map[int]int
+
+	// Lists of the objects of one site, built on first use
+	mu      sync.Mutex
+	bySites map[int]*refList
+}
+
+func newRefList(items []refItem) *refList {
+	sorted := make([]refItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+
+	l := &refList{
+		items: append([]refItem{{Name: "None"}}, sorted...),
+		byID:  make(map[int]int, len(sorted)),
+	}
+	l.names = make([]string, len(l.items))
+	for i, item := range l.items {
+		l.names[i] = item.Name
+		if item.ID != 0 {
+			l.byID[item.ID] = i
+		}
+	}
+	return l
+}
+
+// Names returns the display names in combo order.
+func (l *refList) Names() []string {
+	return l.names
+}
+
+// Len returns the number of items including "None".
+func (l *refList) Len() int {
+	return len(l.items)
+}
+
+// At returns the item at index i, or "None" when i is out of range.
+func (l *refList) At(i int32) refItem {
+	if i < 0 || int(i) >= len(l.items) {
+		return l.items[0]
+	}
+	return l.items[i]
+}
+
+// Index returns the combo index of the object with the given ID, or 0.
+func (l *refList) Index(id int) int32 {
+	return int32(l.byID[id])
+}
+
+// Get returns the object with the given ID.
+func (l *refList) Get(id int) (refItem, bool) {
+	i, ok := l.byID[id]
+	if !ok {
+		return refItem{}, false
+	}
+	return l.items[i], true
+}
+
+// Scope returns the list of the objects that belong to site, or the whole
+// list when site is 0. Names like "Rack 1" repeat across sites, so racks and
+// locations are offered and matched within the device's site.
+func (l *refList) Scope(site int) *refList {
+	if site == 0 {
+		return l
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if scoped, ok := l.bySites[site]; ok {
+		return scoped
+	}
+	var items []refItem
+	for _, item := range l.items[1:] {
+		if item.Site == site {
+			items = append(items, item)
+		}
+	}
+	if l.bySites == nil {
+		l.bySites = map[int]*refList{}
+	}
+	l.bySites[site] = newRefList(items)
+	return l.bySites[site]
+}
+
+// Maximum number of suggestions offered for a name that did not match
+const maxSuggestions = 5
+
+// refMatch is the outcome of matching a name against a refList.
+type refMatch struct {
+	// Item is the matched object; its ID is 0 when nothing matched for
+	// certain.
+	Item  refItem
+	Index int32
+	// Candidates are offered for the user to choose from when the name
+	// matched several objects or only resembles some.
+	Candidates []refItem
+	// Ambiguous is set when several objects matched equally well.
+	Ambiguous bool
+}
+
+// Matched reports whether the name resolved to exactly one object.
+func (m refMatch) Matched() bool {
+	return m.Index != 0
+}
+
+// Match resolves s to an object. An exact name or slug wins, then a unique
+// case-insensitive match. Anything else is not guessed: several equal
+// matches are flagged as ambiguous, and names that only resemble objects
+// get fuzzy suggestions.
+func (l *refList) Match(s string) refMatch {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return refMatch{}
+	}
+
+	var exact, folded []int
+	for i, item := range l.items[1:] {
+		switch {
+		case item.Name == s || item.Slug == s:
+			exact = append(exact, i+1)
+		case strings.EqualFold(item.Name, s) || strings.EqualFold(item.Slug, s):
+			folded = append(folded, i+1)
+		}
+	}
+
+	for _, found := range [][]int{exact, folded} {
+		switch {
+		case len(found) == 1:
+			return refMatch{Item: l.items[found[0]], Index: int32(found[0])}
+		case len(found) > 1:
+			m := refMatch{Ambiguous: true}
+			for _, i := range found {
+				m.Candidates = append(m.Candidates, l.items[i])
+			}
+			return m
+		}
+	}
+
+	var m refMatch
+	for _, f := range fuzzy.Find(s, l.names[1:]) {
+		m.Candidates = append(m.Candidates, l.items[f.Index+1])
+		if len(m.Candidates) == maxSuggestions {
+			break
+		}
+	}
+	return m
+}
+
+// Problem describes why the name did not resolve, for a kind of object
+// such as "site".
+func (m refMatch) Problem(kind, name string) string {
+	names := make([]string, len(m.Candidates))
+	for i, c := range m.Candidates {
+		names[i] = c.Name
+	}
+	switch {
+	case m.Ambiguous:
+		return fmt.Sprintf("%s %q is ambiguous: %s", kind, name, strings.Join(names, ", "))
+	case len(names) > 0:
+		return fmt.Sprintf("unknown %s %q, did you mean %s?", kind, name, strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("unknown %s %q", kind, name)
+}
+
+// registry holds every reference list the UI, the importer and the exporter
+// share. A registry is never modified; refreshes build a new one and swap it
+// in with refData.Store.
+type registry struct {
+	Tenants       *refList
+	Sites         *refList
+	DeviceRoles   *refList
+	DeviceTypes   *refList
+	Manufacturers *refList
+	Locations     *refList
+	Racks         *refList
+	Platforms     *refList
+	Tags          *refList
+	// CustomFields are the custom fields defined for devices.
+	CustomFields []customField
+}
+
+var refData atomic.Pointer[registry]
+
+func init() {
+	refData.Store(emptyRegistry())
+}
+
+// emptyRegistry returns a registry with nothing but "None" in every list.
+func emptyRegistry() *registry {
+	empty := newRefList(nil)
+	return &registry{
+		Tenants:       empty,
+		Sites:         empty,
+		DeviceRoles:   empty,
+		DeviceTypes:   empty,
+		Manufacturers: empty,
+		Locations:     empty,
+		Racks:         empty,
+		Platforms:     empty,
+		Tags:          empty,
+	}
+}
+
+// currentRegistry returns the reference data in use right now.
+func currentRegistry() *registry {
+	return refData.Load()
+}
+
+// updateRegistry publishes a copy of the current registry with the changes
+// made by fn.
+func updateRegistry(fn func(r *registry)) *registry {
+	next := *refData.Load()
+	fn(&next)
+	refData.Store(&next)
+	return &next
+}
+
+// remapChoice keeps a combo on the same object after its list was rebuilt.
+func remapChoice(choice *int32, from, to *refList) {
+	*choice = to.Index(from.At(*choice).ID)
+}
+
+func tenantItems(tenants []Tenant) []refItem {
+	items := make([]refItem, 0, len(tenants))
+	for _, tenant := range tenants {
+		items = append(items, refItem{ID: tenant.ID, Name: tenant.Name, Slug: tenant.Slug})
+	}
+	return items
+}
+
+func namedItems(objects []namedObject) []refItem {
+	items := make([]refItem, 0, len(objects))
+	for _, object := range objects {
+		item := refItem{ID: object.ID, Name: object.Name, Slug: object.Slug}
+		if object.Site != nil {
+			item.Site = object.Site.ID
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// refName returns the registry name of the object with the given ID, or
+// fallback when the registry does not know it.
+func refName(l *refList, id int, fallback string) string {
+	if item, ok := l.Get(id); ok {
+		return item.Name
+	}
+	return fallback
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRefListMatch(t *testing.T) {
+	l := newRefList([]refItem{
+		{ID: 1, Name: "MDF", Slug: "mdf"},
+		{ID: 2, Name: "MDF-2", Slug: "mdf-2"},
+		{ID: 3, Name: "Core", Slug: "core"},
+		{ID: 4, Name: "core", Slug: "core-2"},
+		{ID: 5, Name: "Lab", Slug: "lab-1"},
+		{ID: 6, Name: "Lab", Slug: "lab-2"},
+	})
+
+	tests := []struct {
+		in            string
+		wantID        int
+		wantAmbiguous bool
+		// wantCandidates are the IDs offered, in any order
+		wantCandidates []int
+	}{
+		{"MDF", 1, false, nil},
+		{"mdf", 1, false, nil},
+		{" MDF-2 ", 2, false, nil},
+		{"lab-2", 6, false, nil},
+		{"Core", 3, false, nil},
+		// An exact name and an exact slug of different objects
+		{"core", 0, true, []int{3, 4}},
+		{"CORE", 0, true, []int{3, 4}},
+		{"Lab", 0, true, []int{5, 6}},
+		{"MD", 0, false, []int{1, 2}},
+		{"Spine", 0, false, nil},
+		// Empty cells and the "None" entry never match index 0
+		{"", 0, false, nil},
+		{"   ", 0, false, nil},
+		{"None", 0, false, nil},
+	}
+
+	for _, tt := range tests {
+		m := l.Match(tt.in)
+		if m.Item.ID != tt.wantID || m.Matched() != (tt.wantID != 0) {
+			t.Errorf("Match(%q) = ID %d, matched %v; want ID %d", tt.in, m.Item.ID, m.Matched(), tt.wantID)
+		}
+		if m.Matched() && l.At(m.Index).ID != tt.wantID {
+			t.Errorf("Match(%q) index %d holds ID %d, want %d", tt.in, m.Index, l.At(m.Index).ID, tt.wantID)
+		}
+		if m.Ambiguous != tt.wantAmbiguous {
+			t.Errorf("Match(%q) ambiguous = %v, want %v", tt.in, m.Ambiguous, tt.wantAmbiguous)
+		}
+		var got []int
+		for _, c := range m.Candidates {
+			got = append(got, c.ID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.wantCandidates) {
+			t.Errorf("Match(%q) candidates = %v, want %v", tt.in, got, tt.wantCandidates)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"main/netbox-data-app/netbox"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiServer exposes the app's operations as JSON endpoints. It keeps the last
+// snapshots so repeated listings only fetch what changed, like the GUI does.
+type apiServer struct {
+	mu         sync.Mutex
+	lastVLAN   *vlanSnapshot
+	lastDevice *deviceSnapshot
+	// token is the bearer token callers must send; empty only when
+	// listening on loopback.
+	token string
+}
+
+// runServer starts the headless REST API. args are the flags after "serve".
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	connectNetBox := connectionFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	tokenFile := fs.String("api-token-file", "", "file holding the bearer token API callers must send ("+envAPIToken+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// The API writes with the operator's NetBox token, so anything beyond
+	// this machine has to authenticate
+	token := os.Getenv(envAPIToken)
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading API token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" && !loopbackAddr(*addr) {
+		return fmt.Errorf("refusing to listen on %s without an API token; set %s or -api-token-file, or listen on 127.0.0.1", *addr, envAPIToken)
+	}
+
+	if err := connectNetBox(); err != nil {
+		return err
+	}
+
+	// Keep gin quiet unless asked otherwise
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	srv := &apiServer{token: token}
+	return srv.router().Run(*addr)
+}
+
+// loopbackAddr reports whether addr only accepts connections from this
+// machine. An empty host listens on every interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireToken rejects requests that do not carry the server's bearer
+// token.
+func (s *apiServer) requireToken(c *gin.Context) {
+	if s.token == "" {
+		return
+	}
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid API token"})
+	}
+}
+
+func (s *apiServer) router() *gin.Engine {
+	r := gin.Default()
+
+	api := r.Group("/api", s.requireToken)
+	api.GET("/vlans", s.listVLANs)
+	api.POST("/vlans", s.createVLAN)
+	api.GET("/devices", s.listDevices)
+	api.POST("/devices", s.createDevice)
+	api.POST("/devices/import", s.importDevices)
+	api.GET("/subnets", s.listSubnets)
+	api.POST("/predict", s.predict)
+	api.GET("/plan", s.getPlan)
+	api.GET("/imports", s.listImports)
+	api.POST("/imports/:id/rollback", s.rollbackImport)
+
+	return r
+}
+
+// vlans returns the current VLAN snapshot and refreshes the registry from it.
+func (s *apiServer) vlans() (*vlanSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.lastVLAN
+	if prev.needsFullRefresh() {
+		prev = nil
+	}
+	snap, err := loadVLANTable(prev)
+	if err != nil {
+		return nil, err
+	}
+	s.lastVLAN = snap
+	publishVLANReferences(snap)
+	saveCache()
+	return snap, nil
+}
+
+// devices returns the current device snapshot and refreshes the registry from
+// it.
+func (s *apiServer) devices() (*deviceSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.lastDevice
+	if prev.needsFullRefresh() {
+		prev = nil
+	}
+	snap, err := loadDeviceTable(prev)
+	if err != nil {
+		return nil, err
+	}
+	s.lastDevice = snap
+	publishDeviceReferences(snap)
+	saveCache()
+	return snap, nil
+}
+
+// GET /api/vlans?name=
+func (s *apiServer) listVLANs(c *gin.Context) {
+	snap, err := s.vlans()
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	name := c.Query("name")
+	rows := make([]vlanRow, 0, len(snap.Rows))
+	for _, row := range snap.Rows {
+		if strings.Contains(row.Name, name) {
+			rows = append(rows, row)
+		}
+	}
+	c.JSON(http.StatusOK, rows)
+}
+
+// POST /api/vlans
+func (s *apiServer) createVLAN(c *gin.Context) {
+	var req VLANRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := createVLAN(req); err != nil {
+		apiError(c, err)
+		return
+	}
+	c.JSON(createdStatus(), req)
+}
+
+// GET /api/devices?name=
+func (s *apiServer) listDevices(c *gin.Context) {
+	snap, err := s.devices()
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	name := c.Query("name")
+	devices := make([]DeviceDetails, 0, len(snap.Devices))
+	for _, device := range snap.Devices {
+		if strings.Contains(device.Display, name) {
+			devices = append(devices, device)
+		}
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// POST /api/devices
+func (s *apiServer) createDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status == "" {
+		req.Status = "active"
+	}
+	if err := createDevice(req); err != nil {
+		apiError(c, err)
+		return
+	}
+	c.JSON(createdStatus(), req)
+}
+
+// POST /api/devices/import takes the file to import as the multipart field
+// "file", or imports DeviceToImport.xlsx when none is sent. The optional
+// form fields "sheet" and "template" pick the sheet and the saved column
+// mapping, "batch" the number of devices written per request, "mode"
+// (create, update or upsert) and "match" (name or serial) what happens to
+// rows that match an existing device.
+func (s *apiServer) importDevices(c *gin.Context) {
+	sheet, template := c.PostForm("sheet"), c.PostForm("template")
+	source := "DeviceToImport.xlsx"
+
+	var table *importTable
+	var m columnMapping
+	var err error
+	if header, ferr := c.FormFile("file"); ferr == nil {
+		file, oerr := header.Open()
+		if oerr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": oerr.Error()})
+			return
+		}
+		defer file.Close()
+
+		source = filepath.Base(header.Filename)
+		if template == "" {
+			template = templateName(header.Filename)
+		}
+		t, ok := findImportTemplate(template)
+		if sheet == "" && ok {
+			sheet = t.Sheet
+		}
+		if table, err = readImportData(file, header.Filename, sheet); err == nil {
+			m = table.mapping(t, ok)
+		}
+	} else {
+		if template == "" {
+			template = templateName("DeviceToImport.xlsx")
+		}
+		table, m, err = openImport("DeviceToImport.xlsx", sheet, template)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The importer resolves names through the registry, so load it first.
+	// Registry updates are serialised by s.mu.
+	s.mu.Lock()
+	err = loadTenants()
+	s.mu.Unlock()
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+	snap, err := s.devices()
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	// Every row is reported with its outcome; rows that failed validation
+	// are skipped and failures do not stop the import
+	batchSize := activeProfile.importBatchSize()
+	if v := c.PostForm("batch"); v != "" {
+		if batchSize, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch: " + err.Error()})
+			return
+		}
+	}
+	opts := importOptions{Mode: importCreateOnly, MatchSerial: c.PostForm("match") == "serial"}
+	if v := c.PostForm("mode"); v != "" {
+		if opts.Mode, err = parseImportMode(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	result := importDevices(resolveImportRows(table, m, currentRegistry(), snap.Devices, opts), batchSize, source)
+	journal := ""
+	if result.Journal != nil && len(result.Journal.Entries) > 0 {
+		journal = result.Journal.ID
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"summary":     result.Summary(),
+		"rows":        result.Rows,
+		"requests":    result.Requests,
+		"duration_ms": result.Duration.Milliseconds(),
+		"dry_run":     dryRun.Load(),
+		"journal":     journal,
+	})
+}
+
+// GET /api/imports lists the journals of the imports into this NetBox,
+// newest first.
+func (s *apiServer) listImports(c *gin.Context) {
+	journals, err := listJournals(nbClient.BaseURL())
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+	if journals == nil {
+		journals = []*importJournal{}
+	}
+	c.JSON(http.StatusOK, journals)
+}
+
+// POST /api/imports/:id/rollback deletes the devices the import created and
+// restores the fields it updated.
+func (s *apiServer) rollbackImport(c *gin.Context) {
+	j, err := loadJournal(c.Param("id"))
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no import " + c.Param("id")})
+		return
+	}
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+	if j.RolledBack != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "import already rolled back"})
+		return
+	}
+
+	if err := rollbackImport(j); err != nil {
+		apiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"journal": j, "dry_run": dryRun.Load()})
+}
+
+// GET /api/plan returns the changes collected while serving with -dry-run.
+func (s *apiServer) getPlan(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":  dryRun.Load(),
+		"writes":   plan.Writes(),
+		"problems": plan.Problems(),
+	})
+}
+
+// GET /api/subnets
+func (s *apiServer) listSubnets(c *gin.Context) {
+	prefixes, err := fetchPrefixes()
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, prefixes)
+}
+
+// POST /api/predict
+func (s *apiServer) predict(c *gin.Context) {
+	summary, err := predictDeviceLocation("devices_data.csv")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"summary": summary})
+}
+
+// createdStatus is the status of a successful create: 202 in dry-run mode,
+// where the change was only added to the plan.
+func createdStatus() int {
+	if dryRun.Load() {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+// apiError answers with err and the status that fits it.
+func apiError(c *gin.Context, err error) {
+	body := gin.H{"error": err.Error()}
+
+	var nbErr *netbox.Error
+	if errors.As(err, &nbErr) {
+		body["netbox_status"] = nbErr.StatusCode
+		if nbErr.Fields != nil {
+			body["fields"] = nbErr.Fields
+		}
+	}
+	c.JSON(errorStatus(err), body)
+}
+
+// errorStatus maps an error from NetBox to the status we answer with. NetBox
+// validation errors are the caller's fault; anything else NetBox returns is
+// reported as a bad gateway.
+func errorStatus(err error) int {
+	switch code := netbox.StatusCode(err); {
+	case code == 0:
+		return http.StatusInternalServerError
+	case code == http.StatusBadRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
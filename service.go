@@ -0,0 +1,264 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// screen identifies a table the data service knows how to load.
+type screen int
+
+const (
+	vlanScreen screen = iota
+	deviceScreen
+)
+
+// vlanRow is one row of the VLAN table.
+type vlanRow struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Vid         int      `json:"vid"`
+	Prefixes    []string `json:"prefixes"`
+	Tenant      string   `json:"tenant"`
+	Description string   `json:"description"`
+}
+
+// vlanSnapshot is everything the VLAN screen shows. Snapshots are never
+// modified after they have been published.
+type vlanSnapshot struct {
+	Rows     []vlanRow
+	Tenants  []Tenant
+	VLANs    []VLAN
+	Prefixes []Prefix
+	// Refreshed is when the snapshot was loaded, FullRefresh when every
+	// object was last fetched rather than only the changed ones.
+	Refreshed   time.Time
+	FullRefresh time.Time
+}
+
+// RefreshedAt returns when the snapshot was loaded, or the zero time for a
+// nil snapshot.
+func (s *vlanSnapshot) RefreshedAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.Refreshed
+}
+
+// deviceSnapshot is everything the device screen shows.
+type deviceSnapshot struct {
+	Devices       []DeviceDetails
+	Manufacturers []namedObject
+	DeviceTypes   []namedObject
+	DeviceRoles   []namedObject
+	Sites         []namedObject
+	Locations     []namedObject
+	Racks         []namedObject
+	Platforms     []namedObject
+	Tags          []namedObject
+	CustomFields  []customField
+	Refreshed     time.Time
+	FullRefresh   time.Time
+}
+
+// RefreshedAt returns when the snapshot was loaded, or the zero time for a
+// nil snapshot.
+func (s *deviceSnapshot) RefreshedAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.Refreshed
+}
+
+// refreshRequest asks the worker to reload a screen. Incremental requests
+// only fetch objects changed since the previous load.
+type refreshRequest struct {
+	screen screen
+	full   bool
+}
+
+// dataService owns all NetBox I/O for the GUI. Loads run on a background
+// goroutine; results are handed back to the render loop as closures so the
+// UI state is only ever touched from the giu thread.
+type dataService struct {
+	requests chan refreshRequest
+	updates  chan func()
+	busy     atomic.Int32
+	active   atomic.Int32
+	enabled  atomic.Bool
+	// generation changes on logout so loads started before it are dropped.
+	generation atomic.Int64
+
+	mu        sync.Mutex
+	schedules map[screen]*refreshSchedule
+
+	// Last published snapshots and the generation they belong to, only
+	// touched by the worker goroutine.
+	lastVLAN   *vlanSnapshot
+	lastDevice *deviceSnapshot
+	lastGen    int64
+}
+
+func newDataService() *dataService {
+	return &dataService{
+		requests: make(chan refreshRequest, 4),
+		updates:  make(chan func(), 16),
+		schedules: map[screen]*refreshSchedule{
+			vlanScreen:   {interval: defaultRefreshInterval},
+			deviceScreen: {interval: defaultRefreshInterval},
+		},
+	}
+}
+
+// Start runs the refresh worker and the auto-refresh scheduler until the app
+// exits.
+func (s *dataService) Start() {
+	go func() {
+		for req := range s.requests {
+			s.load(req)
+		}
+	}()
+	go s.schedule()
+}
+
+// Refresh queues a reload of the given screen. A full reload refetches every
+// object; otherwise only objects changed since the last load are fetched.
+// The request is dropped when the queue is already full.
+func (s *dataService) Refresh(sc screen, full bool) {
+	select {
+	case s.requests <- refreshRequest{screen: sc, full: full}:
+		s.busy.Add(1)
+		s.mu.Lock()
+		s.schedules[sc].last = time.Now()
+		s.mu.Unlock()
+	default:
+	}
+}
+
+// SetActive tells the scheduler which screen is on display. Only the active
+// screen is refreshed automatically.
+func (s *dataService) SetActive(sc screen) {
+	s.active.Store(int32(sc))
+}
+
+// Go runs fn in the background and shows the loading indicator until it
+// returns. Use it for one-off NetBox calls triggered by buttons.
+func (s *dataService) Go(fn func()) {
+	s.busy.Add(1)
+	go func() {
+		defer s.busy.Add(-1)
+		defer imgui.Update()
+		fn()
+	}()
+}
+
+// Post schedules fn to run on the render loop and wakes the UI.
+func (s *dataService) Post(fn func()) {
+	s.updates <- fn
+	imgui.Update()
+}
+
+// ApplyUpdates runs every pending update. It must be called from loop().
+func (s *dataService) ApplyUpdates() {
+	for {
+		select {
+		case fn := <-s.updates:
+			fn()
+		default:
+			return
+		}
+	}
+}
+
+// Reset forgets every snapshot loaded so far. Loads still in flight are
+// discarded when they finish.
+func (s *dataService) Reset() {
+	s.generation.Add(1)
+}
+
+// Loading reports whether a refresh or background job is in flight.
+func (s *dataService) Loading() bool {
+	return s.busy.Load() > 0
+}
+
+func (s *dataService) load(req refreshRequest) {
+	defer s.busy.Add(-1)
+	defer imgui.Update()
+
+	gen := s.generation.Load()
+	if gen != s.lastGen {
+		s.lastVLAN, s.lastDevice = nil, nil
+		s.lastGen = gen
+	}
+	current := func() bool { return s.generation.Load() == gen }
+	if req.full {
+		// An explicit refresh also picks up new sites, roles, tenants...
+		objects.Invalidate(screenReferenceKinds[req.screen]...)
+	}
+
+	switch req.screen {
+	case vlanScreen:
+		prev := s.lastVLAN
+		if req.full || prev.needsFullRefresh() {
+			prev = nil
+		}
+		snap, err := loadVLANTable(prev)
+		if err != nil {
+			reportError("loading VLANs", err)
+			return
+		}
+		if !current() {
+			return
+		}
+		s.lastVLAN = snap
+		if !offline.Load() {
+			saveCache()
+		}
+		s.Post(func() {
+			if current() {
+				applyVLANSnapshot(snap)
+			}
+		})
+	case deviceScreen:
+		prev := s.lastDevice
+		if req.full || prev.needsFullRefresh() {
+			prev = nil
+		}
+		snap, err := loadDeviceTable(prev)
+		if err != nil {
+			reportError("loading devices", err)
+			return
+		}
+		if !current() {
+			return
+		}
+		s.lastDevice = snap
+		if !offline.Load() {
+			saveCache()
+		}
+		s.Post(func() {
+			if current() {
+				applyDeviceSnapshot(snap, req.full)
+			}
+		})
+	}
+}
+
+// loadingIndicator shows a spinner while the data service is busy.
+func loadingIndicator() imgui.Widget {
+	return imgui.Condition(dataSvc.Loading(),
+		imgui.ProgressIndicator("Loading", 20, 20, 8),
+		imgui.Dummy(20, 20),
+	)
+}
+
+// refreshedLabel describes when a table was last loaded.
+func refreshedLabel(refreshed time.Time) string {
+	if refreshed.IsZero() {
+		return "Not loaded yet"
+	}
+	return "Last refreshed: " + refreshed.Format("15:04:05")
+}
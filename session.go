@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"main/netbox-data-app/netbox"
+
+	imgui "github.com/AllenDang/giu"
+)
+
+// session describes who is logged in to which NetBox.
+type session struct {
+	Domain  string
+	Version string
+	User    string
+	// CanWrite is false for read-only tokens. It is only meaningful when
+	// WriteKnown is set: without the token's own record NetBox gives no way
+	// to tell short of writing.
+	CanWrite   bool
+	WriteKnown bool
+	Offline    bool
+	Started    time.Time
+}
+
+// apiToken is the subset of /api/users/tokens/ the login check needs.
+type apiToken struct {
+	ID  int    `json:"id"`
+	Key string `json:"key"`
+	// Display is the key, or where NetBox hides keys, asterisks followed by
+	// its last characters.
+	Display      string `json:"display"`
+	WriteEnabled bool   `json:"write_enabled"`
+	User         struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// loginError explains why a login attempt failed.
+type loginError struct {
+	Reason string
+	Err    error
+	// Unreachable is set when NetBox could not be contacted at all.
+	Unreachable bool
+}
+
+func (e *loginError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *loginError) Unwrap() error {
+	return e.Err
+}
+
+// verifyLogin checks that NetBox is reachable and accepts token, and finds
+// out the NetBox version, who the token belongs to and whether it may write.
+func verifyLogin(ctx context.Context, domain, token string) (*session, error) {
+	s := &session{Domain: domain, Started: time.Now()}
+
+	status, httpResp, err := apiClient.StatusAPI.StatusRetrieve(ctx).Execute()
+	if err != nil {
+		if httpResp == nil {
+			return nil, &loginError{Reason: "cannot reach NetBox at " + domain, Err: err, Unreachable: true}
+		}
+		return nil, &loginError{Reason: fmt.Sprintf("NetBox answered %s", httpResp.Status), Err: err}
+	}
+	if v, ok := status["netbox-version"].(string); ok {
+		s.Version = v
+	}
+	applyVersion(s.Version)
+
+	// The status endpoint may be public, so the token is only proven by the
+	// token listing. Read-only users can always list their own tokens. The
+	// token is never sent as a filter, as URLs end up in errors and logs.
+	tokens, err := netbox.ListAll[apiToken](ctx, nbClient, "/api/users/tokens/", nil, netbox.ListOptions{})
+	if err != nil {
+		switch netbox.StatusCode(err) {
+		case http.StatusForbidden, http.StatusUnauthorized:
+			return nil, &loginError{Reason: "NetBox rejected the token", Err: err}
+		case 0:
+			return nil, &loginError{Reason: "cannot reach NetBox at " + domain, Err: err, Unreachable: true}
+		}
+		return nil, &loginError{Reason: "cannot verify the token", Err: err}
+	}
+
+	// An admin sees the tokens of every user. Without exactly one match the
+	// user and write access stay unknown rather than guessed; NetBox still
+	// refuses writes the token may not make
+	var matched []apiToken
+	for _, t := range tokens {
+		if t.Matches(token) {
+			matched = append(matched, t)
+		}
+	}
+	if len(matched) == 1 {
+		s.User = matched[0].User.Username
+		s.CanWrite = matched[0].WriteEnabled
+		s.WriteKnown = true
+	}
+	return s, nil
+}
+
+// Matches reports whether t is the token with the given key. Where NetBox
+// hides keys only their last characters are compared.
+func (t apiToken) Matches(key string) bool {
+	if t.Key != "" && !strings.Contains(t.Key, "*") {
+		return t.Key == key
+	}
+	partial := strings.TrimLeft(t.Display, "*")
+	if len(partial) == len(t.Display) || len(partial) < 4 {
+		return false
+	}
+	return strings.HasSuffix(key, partial)
+}
+
+// detectVersion reads the NetBox release from /api/status/ and adapts the
+// client to it.
+func detectVersion(ctx context.Context) (string, error) {
+	status, _, err := apiClient.StatusAPI.StatusRetrieve(ctx).Execute()
+	if err != nil {
+		return "", err
+	}
+	v, _ := status["netbox-version"].(string)
+	applyVersion(v)
+	return v, nil
+}
+
+// applyVersion makes the client use the field names of NetBox release v.
+// An unknown version is treated as the newest release.
+func applyVersion(v string) {
+	version, err := netbox.ParseVersion(v)
+	if err != nil {
+		logError("reading NetBox version", err)
+	}
+	nbClient.SetVersion(version)
+}
+
+// Label describes the session for the toolbar.
+func (s *session) Label() string {
+	if s == nil {
+		return "Not logged in"
+	}
+	host := s.Domain
+	if u, err := url.Parse(s.Domain); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	label := host
+	if s.User != "" {
+		label = s.User + "@" + host
+	}
+	if s.Version != "" {
+		label += " (NetBox " + s.Version + ")"
+	}
+	switch {
+	case s.Offline:
+		label += " offline"
+	case !s.WriteKnown:
+		label += " write access unknown"
+	case !s.CanWrite:
+		label += " read-only"
+	}
+	return label
+}
+
+// isUnreachable reports whether err is a login failure because NetBox could
+// not be contacted.
+func isUnreachable(err error) bool {
+	var le *loginError
+	return errors.As(err, &le) && le.Unreachable
+}
+
+// ReadOnly reports whether changes must be disabled. Tokens whose write
+// access is unknown may try; NetBox refuses what they may not do.
+func (s *session) ReadOnly() bool {
+	return s == nil || s.Offline || (s.WriteKnown && !s.CanWrite)
+}
+
+// logOut forgets the NetBox instance and everything loaded from it, and
+// shows the login window again.
+func logOut() {
+	dataSvc.EnableSchedule(false)
+	dataSvc.Reset()
+
+	if cacheDomain != "" && !offline.Load() {
+		saveCache()
+	}
+	objects.Clear()
+	cacheDomain = ""
+
+	currentSession = nil
+	offline.Store(false)
+
+	vlanTable = nil
+	deviceTable = nil
+	refData.Store(emptyRegistry())
+	tenantChoice = 0
+	deviceTypeChoice = 0
+	deviceManufacturerChoice = 0
+	deviceSiteChoice = 0
+	deviceRoleChoice = 0
+	deviceStatusChoice = 1
+	deviceLocationChoice = 0
+	deviceRackChoice = 0
+	devicePlatformChoice = 0
+	deviceFaceChoice = 0
+	deviceAirflowChoice = 0
+	inputDevicePosition = 0
+	inputDeviceAssetTag = ""
+	deviceTagChecked = map[int]bool{}
+	inputDeviceCustomFields = map[string]*string{}
+	resetImportWindow()
+
+	showDeviceScreen = false
+	showEnterVLANWindow = false
+	showEnterDeviceWindow = false
+	loginFailure = ""
+	showLoggedIn = true
+	logInfo("Logged out")
+}
+
+// sessionBar shows who is logged in and offers to log out.
+func sessionBar() imgui.Widget {
+	return imgui.Row(
+		imgui.Label(currentSession.Label()),
+		imgui.Button("Log Out / Switch Instance").OnClick(logOut),
+	)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"main/netbox-data-app/netbox"
+)
+
+// Timeouts used when a profile does not set its own
+const (
+	defaultHTTPTimeout         = 60 * time.Second
+	defaultConnectTimeout      = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TLSOptions controls how the NetBox server is trusted and how we
+// authenticate to it.
+type TLSOptions struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// ClientCert and ClientKey are a PEM certificate and key presented to
+	// servers that require mutual TLS.
+	ClientCert string `yaml:"client_cert,omitempty"`
+	ClientKey  string `yaml:"client_key,omitempty"`
+	// ServerName overrides the name the certificate is checked against.
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Timeouts of the requests made to NetBox. Zero values use the defaults.
+type Timeouts struct {
+	// Request bounds a whole request including reading the response.
+	Request      time.Duration `yaml:"request,omitempty"`
+	Connect      time.Duration `yaml:"connect,omitempty"`
+	TLSHandshake time.Duration `yaml:"tls_handshake,omitempty"`
+}
+
+// Retry controls how failed NetBox requests are retried. Zero values use
+// the client defaults.
+type Retry struct {
+	// MaxAttempts is the total number of tries; 1 disables retries.
+	MaxAttempts int           `yaml:"max_attempts,omitempty"`
+	BaseDelay   time.Duration `yaml:"base_delay,omitempty"`
+	MaxDelay    time.Duration `yaml:"max_delay,omitempty"`
+}
+
+// policy returns the retry policy of the NetBox client.
+func (r Retry) policy() netbox.RetryPolicy {
+	p := netbox.DefaultRetryPolicy
+	if r.MaxAttempts > 0 {
+		p.MaxAttempts = r.MaxAttempts
+	}
+	if r.BaseDelay > 0 {
+		p.BaseDelay = r.BaseDelay
+	}
+	if r.MaxDelay > 0 {
+		p.MaxDelay = r.MaxDelay
+	}
+	return p
+}
+
+// HTTPClient returns the http.Client every NetBox request of the profile is
+// sent through, with its TLS, proxy and timeout options applied.
+func (p Profile) HTTPClient() (*http.Client, error) {
+	tlsConfig, err := p.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("TLS options: %w", err)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if p.Proxy != "" {
+		proxyURL, err := url.Parse(p.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   orDefault(p.Timeouts.Connect, defaultConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = tlsConfig
+	transport.TLSHandshakeTimeout = orDefault(p.Timeouts.TLSHandshake, defaultTLSHandshakeTimeout)
+
+	return &http.Client{
+		Timeout:   orDefault(p.Timeouts.Request, defaultHTTPTimeout),
+		Transport: transport,
+	}, nil
+}
+
+func (o TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.ClientCert != "" || o.ClientKey != "" {
+		if o.ClientCert == "" || o.ClientKey == "" {
+			return nil, errors.New("client_cert and client_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
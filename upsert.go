@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// importMode says what the importer does with rows that match a device
+// already in NetBox.
+type importMode string
+
+const (
+	// importCreateOnly creates new devices and rejects rows that match
+	// an existing one.
+	importCreateOnly importMode = "create"
+	// importUpdateOnly updates the devices rows match and skips the rest.
+	importUpdateOnly importMode = "update"
+	// importUpsert updates the devices rows match and creates the rest.
+	importUpsert importMode = "upsert"
+)
+
+var importModes = []importMode{importCreateOnly, importUpdateOnly, importUpsert}
+
+// Labels of the import modes for the mode combo, in importModes order
+var importModeLabels = []string{"Create only", "Update only", "Create or update"}
+
+// parseImportMode returns the mode called s.
+func parseImportMode(s string) (importMode, error) {
+	for _, mode := range importModes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown import mode %q, want create, update or upsert", s)
+}
+
+// importOptions control how rows are matched to existing devices.
+type importOptions struct {
+	Mode importMode
+	// MatchSerial matches devices by serial number instead of by name and
+	// site.
+	MatchSerial bool
+}
+
+// What the importer does with a row
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionUnchanged = "unchanged"
+)
+
+// fieldChange is a device field an import changes. Old and New are the
+// values as NetBox takes them: IDs for related objects.
+type fieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// deviceIndex finds existing devices by name and site or by serial.
+type deviceIndex struct {
+	byNameSite map[string][]*DeviceDetails
+	bySerial   map[string][]*DeviceDetails
+}
+
+func nameSiteKey(name string, site int) string {
+	return fmt.Sprintf("%s\x00%d", strings.ToLower(name), site)
+}
+
+func newDeviceIndex(devices []DeviceDetails) *deviceIndex {
+	idx := &deviceIndex{
+		byNameSite: make(map[string][]*DeviceDetails, len(devices)),
+		bySerial:   make(map[string][]*DeviceDetails, len(devices)),
+	}
+	for i := range devices {
+		device := &devices[i]
+		key := nameSiteKey(device.Name, device.Site.ID)
+		idx.byNameSite[key] = append(idx.byNameSite[key], device)
+		if device.Serial != "" {
+			idx.bySerial[device.Serial] = append(idx.bySerial[device.Serial], device)
+		}
+	}
+	return idx
+}
+
+// Find returns the existing device req refers to, or nil. It fails when
+// several devices match.
+func (idx *deviceIndex) Find(req DeviceRequest, bySerial bool) (*DeviceDetails, error) {
+	var found []*DeviceDetails
+	switch {
+	case bySerial && req.Serial != "":
+		found = idx.bySerial[req.Serial]
+	case !bySerial && req.Name != "" && req.Site != 0:
+		found = idx.byNameSite[nameSiteKey(req.Name, req.Site)]
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	if bySerial {
+		return nil, fmt.Errorf("%d devices have serial %q", len(found), req.Serial)
+	}
+	return nil, fmt.Errorf("%d devices are called %q at this site", len(found), req.Name)
+}
+
+// deviceChanges lists the fields of device that req changes. Fields the
+// spreadsheet leaves empty are left as they are. Tags are replaced as a set;
+// custom fields are changed one by one and NetBox keeps the others.
+func deviceChanges(req DeviceRequest, device *DeviceDetails) []fieldChange {
+	var changes []fieldChange
+	add := func(field string, old, new interface{}) {
+		if old != new {
+			changes = append(changes, fieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	if req.Name != "" {
+		add("name", device.Name, req.Name)
+	}
+	if req.Serial != "" {
+		add("serial", device.Serial, req.Serial)
+	}
+	if req.Status != "" {
+		add("status", device.Status.Value, req.Status)
+	}
+	if req.DeviceType != 0 {
+		add("device_type", device.DeviceType.ID, req.DeviceType)
+	}
+	if req.DeviceRole != 0 {
+		add("role", device.DeviceRole.ID, req.DeviceRole)
+	}
+	if req.Site != 0 {
+		add("site", device.Site.ID, req.Site)
+	}
+	if req.Tenant != 0 {
+		add("tenant", device.Tenant.ID, req.Tenant)
+	}
+	if req.Location != 0 {
+		add("location", device.Location.ID, req.Location)
+	}
+	if req.Rack != 0 {
+		add("rack", device.Rack.ID, req.Rack)
+	}
+	if req.Position != 0 {
+		add("position", device.Position, req.Position)
+	}
+	if req.Face != "" {
+		add("face", device.Face.Value, req.Face)
+	}
+	if req.Platform != 0 {
+		add("platform", device.Platform.ID, req.Platform)
+	}
+	if req.AssetTag != "" {
+		add("asset_tag", device.AssetTag, req.AssetTag)
+	}
+	if req.Airflow != "" {
+		add("airflow", device.Airflow.Value, req.Airflow)
+	}
+
+	if len(req.Tags) > 0 {
+		old := make([]int, len(device.Tags))
+		for i, tag := range device.Tags {
+			old[i] = tag.ID
+		}
+		if !sameIDs(old, req.Tags) {
+			changes = append(changes, fieldChange{Field: "tags", Old: old, New: req.Tags})
+		}
+	}
+
+	// Values are compared as JSON, as numbers decode to float64
+	oldFields, newFields := map[string]interface{}{}, map[string]interface{}{}
+	for name, value := range req.CustomFields {
+		old := device.CustomFields[name]
+		a, _ := json.Marshal(old)
+		b, _ := json.Marshal(value)
+		if string(a) != string(b) {
+			oldFields[name], newFields[name] = old, value
+		}
+	}
+	if len(newFields) > 0 {
+		changes = append(changes, fieldChange{Field: "custom_fields", Old: oldFields, New: newFields})
+	}
+	return changes
+}
+
+// sameIDs reports whether a and b hold the same IDs in any order.
+func sameIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// devicePatch returns the body that applies changes to the device with the
+// given ID in a bulk update.
+func devicePatch(id int, changes []fieldChange) map[string]interface{} {
+	patch := map[string]interface{}{"id": id}
+	for _, c := range changes {
+		patch[c.Field] = c.New
+	}
+	return patch
+}
+
+// describeChanges shows changes with the names of related objects.
+func describeChanges(changes []fieldChange, reg *registry) string {
+	lists := map[string]*refList{
+		"device_type": reg.DeviceTypes,
+		"role":        reg.DeviceRoles,
+		"site":        reg.Sites,
+		"tenant":      reg.Tenants,
+		"location":    reg.Locations,
+		"rack":        reg.Racks,
+		"platform":    reg.Platforms,
+	}
+	value := func(field string, v interface{}) string {
+		switch v := v.(type) {
+		case int:
+			if v == 0 {
+				return "none"
+			}
+			if l, ok := lists[field]; ok {
+				return refName(l, v, fmt.Sprint(v))
+			}
+		case float64:
+			if v == 0 {
+				return "none"
+			}
+			return fmt.Sprint(v)
+		case []int:
+			names := make([]string, len(v))
+			for i, id := range v {
+				names[i] = refName(reg.Tags, id, fmt.Sprint(id))
+			}
+			return "[" + strings.Join(names, ", ") + "]"
+		case map[string]interface{}:
+			data, _ := json.Marshal(v)
+			return string(data)
+		}
+		return fmt.Sprintf("%q", v)
+	}
+
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = fmt.Sprintf("%s: %s -> %s", c.Field, value(c.Field, c.Old), value(c.Field, c.New))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDeviceChanges(t *testing.T) {
+	var device DeviceDetails
+	err := json.Unmarshal([]byte(`{
+		"id": 7, "name": "sw1", "serial": "A1", "status": {"value": "active"},
+		"site": {"id": 1}, "tenant": {"id": 2}, "position": 10,
+		"tags": [{"id": 1}, {"id": 2}],
+		"custom_fields": {"units": 2, "owner": "net"}
+	}`), &device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  DeviceRequest
+		want string
+	}{
+		{"empty fields are skipped", DeviceRequest{}, ""},
+		{"same values", DeviceRequest{Name: "sw1", Serial: "A1", Site: 1, Status: "active", Position: 10}, ""},
+		{"serial", DeviceRequest{Serial: "B2"}, "serial:A1->B2"},
+		{"status", DeviceRequest{Status: "planned"}, "status:active->planned"},
+		{"tenant", DeviceRequest{Tenant: 3}, "tenant:2->3"},
+		{"position", DeviceRequest{Position: 11}, "position:10->11"},
+		{"tags in another order", DeviceRequest{Tags: []int{2, 1}}, ""},
+		{"tag removed", DeviceRequest{Tags: []int{1}}, "tags:[1 2]->[1]"},
+		{"tag added", DeviceRequest{Tags: []int{1, 2, 3}}, "tags:[1 2]->[1 2 3]"},
+		{"custom field numbers compare as JSON", DeviceRequest{CustomFields: map[string]interface{}{"units": 2}}, ""},
+		{"only changed custom fields", DeviceRequest{CustomFields: map[string]interface{}{"units": 3, "owner": "net"}}, "custom_fields:map[units:2]->map[units:3]"},
+		{"new custom field", DeviceRequest{CustomFields: map[string]interface{}{"rack_row": "A"}}, "custom_fields:map[rack_row:<nil>]->map[rack_row:A]"},
+		{"several fields", DeviceRequest{Serial: "B2", Tenant: 3}, "serial:A1->B2 tenant:2->3"},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, c := range deviceChanges(tt.req, &device) {
+			got = append(got, fmt.Sprintf("%s:%v->%v", c.Field, c.Old, c.New))
+		}
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("%s: changes = %q, want %q", tt.name, s, tt.want)
+		}
+	}
+}